@@ -0,0 +1,142 @@
+package cast
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fails the first connection attempts
+type failingInterface struct {
+	iface Interface
+	fail  atomic.Int32
+}
+
+var errTestConnect = errors.New("test connect failed")
+
+func (i *failingInterface) Connect() (Connection, error) {
+	if i.fail.Add(-1) >= 0 {
+		return nil, errTestConnect
+	}
+
+	return i.iface.Connect()
+}
+
+func TestBackoff(t *testing.T) {
+	for i, expected := range []time.Duration{10, 20, 40, 80, 100, 100} {
+		if d := backoff(i+1, 10, 100, 0); d != expected {
+			t.Error("invalid backoff", i+1, d)
+		}
+	}
+
+	for i := 0; i < 30; i++ {
+		if d := backoff(2, 1000, 10000, 0.5); d < 1000 || d > 2000 {
+			t.Error("invalid jitter", d)
+		}
+	}
+}
+
+func TestRecoveryBackoff(t *testing.T) {
+	p := NewNode(0, 0)
+	l := make(InProcListener)
+	p.Listen(l)
+
+	fi := &failingInterface{iface: l}
+	fi.fail.Store(2)
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:        []Interface{fi},
+		InitialBackoff: time.Millisecond})
+
+	testTimeout(t, func() {
+		for _, backoff := range []time.Duration{time.Millisecond, 2 * time.Millisecond} {
+			e := <-n.(EventSource).Events()
+			if e.Type != ReconnectFailed || e.Interface != fi || e.Err != errTestConnect ||
+				e.Backoff != backoff {
+				t.Error("invalid event", e.Type, e.Backoff)
+			}
+		}
+
+		if e := <-n.(EventSource).Events(); e.Type != ParentSelected || e.Interface != fi || e.Strategy != StrategyOrdered {
+			t.Error("invalid event", e.Type)
+		}
+
+		if e := <-n.(EventSource).Events(); e.Type != ParentChanged {
+			t.Error("invalid event", e.Type)
+		}
+	})
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		if m := <-p.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestRecoveryTimeout(t *testing.T) {
+	fi := &failingInterface{}
+	fi.fail.Store(1 << 30)
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:         []Interface{fi},
+		RecoveryTimeout: 18 * time.Millisecond,
+		InitialBackoff:  3 * time.Millisecond})
+
+	events := make(chan Event, 64)
+	go func() {
+		for e := range n.(EventSource).Events() {
+			events <- e
+		}
+	}()
+
+	testTimeout(t, func() {
+		err := <-n.Error()
+		var ne *NodeError
+		if !errors.As(err, &ne) || ne.Kind != ErrorRecoveryFailed || ne.Err != ErrRecoveryFailed {
+			t.Error("invalid error", err)
+		}
+	})
+
+	time.Sleep(15 * time.Millisecond)
+	attempts := len(events)
+	if attempts == 0 {
+		t.Error("failed to report the attempts")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if len(events) != attempts {
+		t.Error("failed to give up")
+	}
+}
+
+func TestRecoveryFinalAttempt(t *testing.T) {
+	fi := &failingInterface{}
+	fi.fail.Store(1 << 30)
+	start := time.Now()
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:         []Interface{fi},
+		RecoveryTimeout: 60 * time.Millisecond,
+		InitialBackoff:  40 * time.Millisecond})
+
+	testTimeout(t, func() {
+		if e := <-n.(EventSource).Events(); e.Type != ReconnectFailed || e.Backoff != 40*time.Millisecond {
+			t.Error("invalid event", e.Type, e.Backoff)
+		}
+
+		if e := <-n.(EventSource).Events(); e.Type != ReconnectFailed || e.Backoff <= 0 || e.Backoff > 20*time.Millisecond {
+			t.Error("failed to shorten the last backoff", e.Type, e.Backoff)
+		}
+
+		if e := <-n.(EventSource).Events(); e.Type != ReconnectFailed || e.Backoff != 0 {
+			t.Error("invalid final attempt", e.Type, e.Backoff)
+		}
+
+		if err := <-n.Error(); !errors.Is(err, ErrRecoveryFailed) {
+			t.Error("invalid error", err)
+		}
+	})
+
+	if time.Since(start) < 60*time.Millisecond {
+		t.Error("gave up before the recovery timeout")
+	}
+}
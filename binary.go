@@ -0,0 +1,161 @@
+package cast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// the maximum accepted size of a binary frame
+const maxBinaryFrame = 1 << 26
+
+type binaryCodec struct{}
+
+// writes messages in the binary format
+type BinaryEncoder struct {
+	writer io.Writer
+}
+
+// reads messages in the binary format
+type BinaryDecoder struct {
+	reader *bufio.Reader
+	offset int64
+	frame  int64
+}
+
+// length prefixed binary format
+//
+// every frame starts with the length of the rest of the frame. the
+// rest of the frame contains the number of the key segments, each key
+// segment prefixed with its length, the value prefixed with its
+// length, and, optionally, the comment prefixed with its length. all
+// numbers are written as unsigned varints.
+var BinaryCodec Codec = binaryCodec{}
+
+func (binaryCodec) Name() string                   { return "binary" }
+func (binaryCodec) NewEncoder(w io.Writer) Encoder { return NewBinaryEncoder(w) }
+func (binaryCodec) NewDecoder(r io.Reader) Decoder { return NewBinaryDecoder(r) }
+
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	return &BinaryEncoder{writer: w}
+}
+
+func appendBinaryString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// writes a single message with a single call to the underlying writer
+func (e *BinaryEncoder) Encode(m Message) error {
+	body := binary.AppendUvarint(nil, uint64(len(m.Key)))
+	for _, s := range m.Key {
+		body = appendBinaryString(body, s)
+	}
+
+	body = appendBinaryString(body, m.Val)
+	if m.Comment != "" {
+		body = appendBinaryString(body, m.Comment)
+	}
+
+	if len(body) > maxBinaryFrame {
+		return &FrameError{Reason: "frame too large"}
+	}
+
+	frame := binary.AppendUvarint(make([]byte, 0, len(body)+binary.MaxVarintLen64), uint64(len(body)))
+	_, err := e.writer.Write(append(frame, body...))
+	return err
+}
+
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	return &BinaryDecoder{reader: bufio.NewReader(r)}
+}
+
+func (d *BinaryDecoder) frameError(reason string) error {
+	return &FrameError{Offset: d.frame, Reason: reason}
+}
+
+func (d *BinaryDecoder) readFrame() ([]byte, error) {
+	d.frame = d.offset
+	l, err := binary.ReadUvarint(d.reader)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return nil, d.frameError("unexpected end of stream")
+	} else if err != nil {
+		return nil, d.frameError("invalid frame length")
+	}
+
+	if l > maxBinaryFrame {
+		return nil, d.frameError("frame too large")
+	}
+
+	body := make([]byte, l)
+	if _, err := io.ReadFull(d.reader, body); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, d.frameError("unexpected end of stream")
+	} else if err != nil {
+		return nil, err
+	}
+
+	d.offset += int64(len(binary.AppendUvarint(nil, l))) + int64(l)
+	return body, nil
+}
+
+func (d *BinaryDecoder) readString(body []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(body)
+	if n <= 0 || l > uint64(len(body)-n) {
+		return "", nil, d.frameError("invalid field length")
+	}
+
+	body = body[n:]
+	return string(body[:l]), body[l:], nil
+}
+
+// reads the next message
+// blocks until a full frame is available
+// it returns io.EOF if the stream is closed between two frames, and
+// *FrameError when the frame is malformed or the stream ends in the
+// middle of a frame.
+func (d *BinaryDecoder) Decode() (Message, error) {
+	body, err := d.readFrame()
+	if err != nil {
+		return Message{}, err
+	}
+
+	count, n := binary.Uvarint(body)
+	if n <= 0 || count > uint64(len(body)) {
+		return Message{}, d.frameError("invalid key length")
+	}
+
+	body = body[n:]
+
+	var m Message
+	if count > 0 {
+		m.Key = make([]string, count)
+	}
+
+	for i := range m.Key {
+		if m.Key[i], body, err = d.readString(body); err != nil {
+			return Message{}, err
+		}
+	}
+
+	if m.Val, body, err = d.readString(body); err != nil {
+		return Message{}, err
+	}
+
+	if len(body) > 0 {
+		if m.Comment, body, err = d.readString(body); err != nil {
+			return Message{}, err
+		}
+
+		if m.Comment == "" {
+			return Message{}, d.frameError("empty comment")
+		}
+	}
+
+	if len(body) > 0 {
+		return Message{}, d.frameError("unexpected data at the end of frame")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,79 @@
+package cast
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	testRoundTrip(t, BinaryCodec, codecTestMessages, func(r io.Reader) io.Reader { return r })
+}
+
+func TestBinaryPartialReads(t *testing.T) {
+	testRoundTrip(t, BinaryCodec, codecTestMessages, iotest.OneByteReader)
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	for _, ti := range []struct {
+		msg    string
+		frame  []byte
+		offset int64
+	}{{
+		"truncated length", []byte{0x80}, 0,
+	}, {
+		"truncated frame", []byte{3, 0, 0}, 0,
+	}, {
+		"too large", []byte{0xff, 0xff, 0xff, 0xff, 0x7f}, 0,
+	}, {
+		"invalid key length", []byte{1, 9}, 0,
+	}, {
+		"invalid field length", []byte{3, 1, 5, 0}, 0,
+	}, {
+		"missing value", []byte{1, 0}, 0,
+	}, {
+		"empty comment", []byte{3, 0, 0, 0}, 0,
+	}, {
+		"trailing data", []byte{5, 0, 0, 1, 'a', 0}, 0,
+	}, {
+		"second frame", []byte{2, 0, 0, 1, 0}, 3,
+	}} {
+		dec := NewBinaryDecoder(bytes.NewBuffer(ti.frame))
+
+		var err error
+		for err == nil {
+			_, err = dec.Decode()
+		}
+
+		ferr, ok := err.(*FrameError)
+		if !ok {
+			t.Error(ti.msg, "failed to report frame error", err)
+			continue
+		}
+
+		if ferr.Offset != ti.offset {
+			t.Error(ti.msg, "invalid offset reported", ferr.Offset)
+		}
+	}
+}
+
+func FuzzBinaryCodec(f *testing.F) {
+	addCodecSeeds(f)
+	f.Fuzz(func(t *testing.T, hasKey bool, key, val, comment string) {
+		testFuzzRoundTrip(t, BinaryCodec, hasKey, key, val, comment)
+	})
+}
+
+func FuzzBinaryDecode(f *testing.F) {
+	var b bytes.Buffer
+	enc := NewBinaryEncoder(&b)
+	for _, m := range codecTestMessages {
+		enc.Encode(m)
+	}
+
+	f.Add(b.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		testFuzzDecode(t, BinaryCodec, data)
+	})
+}
@@ -30,6 +30,7 @@ unifies in-process and network communication
 package cast
 
 import (
+	"context"
 	"errors"
 
 	// the statement behind this import:
@@ -87,6 +88,8 @@ type Interface interface {
 
 // ok, this is becoming enterprisy, stop here.
 // the reality cannot be like this
+// returns nil when the message doesn't represent an interface
+// see InterfaceRegistry for the implementation of the built-in transports
 type InterfaceTranslation interface {
 	Translate(Message) Interface
 }
@@ -114,6 +117,12 @@ type InterfaceTranslation interface {
 // node error routine never exits
 // similarities and differences between node and go channel communication
 // takes over owner ship of connection close
+// messages are stamped with the id of the origin node and a sequence
+// number, and the ones arriving again through circular connections are
+// dropped
+// the direction of the broadcast messages can be limited with WithScope
+// the nodes created by NewNode and NewRecoveryNode implement also
+// Monitor, Router, Subscriber, EventSource and Shutdowner
 type Node interface {
 	Connection
 	Join(Connection)
@@ -121,12 +130,52 @@ type Node interface {
 	Error() <-chan error
 }
 
+// reports the counters of a node, see NodeStats
+type Monitor interface {
+	Stats() NodeStats
+}
+
+// every node has an id, and the nodes advertise the ids reachable
+// through them to their parent, so that a message sent with SendTo
+// travels only along the path in the tree
+type Router interface {
+	ID() string
+	SendTo(id string, m Message)
+}
+
+// the subscriptions of the nodes are merged and advertised to the parent,
+// so that the messages are sent only to the interested subtrees
+type Subscriber interface {
+	Subscribe(prefix []string)
+	Unsubscribe(prefix []string)
+}
+
+// the changes of the parent and the children are reported as events. the
+// events are buffered until they are received, and dropped when the
+// buffer is full.
+type EventSource interface {
+	Events() <-chan Event
+}
+
+// a node can be shut down gracefully, delivering the pending messages
+// before closing the connections
+type Shutdowner interface {
+	Shutdown(ctx context.Context) (int, error)
+}
+
 var (
 	// error sent when parent is disconnected
 	ErrDisconnected = errors.New("disconnected")
 
 	// error sent when active listener is disconnected
 	ErrListenerDisconnected = errors.New("listener disconnected")
+
+	// error sent when a connection was closed, because nothing was
+	// received from it during the heartbeat timeout
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+	// error returned by Shutdown when the node is already closed
+	ErrClosed = errors.New("node closed")
 )
 
 // self healing network
@@ -0,0 +1,337 @@
+package cast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writes messages to a byte stream
+type Encoder interface {
+	Encode(Message) error
+}
+
+// reads messages from a byte stream
+// returns io.EOF when the stream ends between two messages
+type Decoder interface {
+	Decode() (Message, error)
+}
+
+// a wire format of messages
+// it can be used to carry node traffic over any byte stream
+// the name identifies the codec during the connection handshake
+type Codec interface {
+	Name() string
+	NewEncoder(io.Writer) Encoder
+	NewDecoder(io.Reader) Decoder
+}
+
+// error returned when a malformed frame is read from a stream
+// the text format reports the line, the binary format the byte offset
+// of the frame
+type FrameError struct {
+	Line   int
+	Offset int64
+	Reason string
+}
+
+// the maximum accepted size of a text frame, including the comment lines
+// and the continued lines of the value
+const maxTextFrame = 1 << 26
+
+type textCodec struct{}
+
+// writes messages in the keyval text format
+type TextEncoder struct {
+	writer io.Writer
+}
+
+// reads messages in the keyval text format
+type TextDecoder struct {
+	reader   *bufio.Reader
+	line     int
+	frame    int
+	maxFrame int
+}
+
+// the keyval text format
+//
+// every message is written as an optional comment, one line for each
+// line of the comment prefixed with '#', followed by a 'key = value'
+// line.
+//
+// key segments are separated by '.'. in the key, the characters '\',
+// '.', '=', '#' and '[' are escaped with '\', and a new line is written
+// as '\n'. a key of a single empty segment is written as '\_'.
+//
+// in the value, '\' is escaped with '\', and new lines are continued
+// with a '\' at the end of the line.
+//
+// empty lines between messages are ignored.
+var TextCodec Codec = textCodec{}
+
+func (e *FrameError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("malformed frame at line %d: %s", e.Line, e.Reason)
+	}
+
+	return fmt.Sprintf("malformed frame at offset %d: %s", e.Offset, e.Reason)
+}
+
+func (textCodec) Name() string                   { return "text" }
+func (textCodec) NewEncoder(w io.Writer) Encoder { return NewTextEncoder(w) }
+func (textCodec) NewDecoder(r io.Reader) Decoder { return NewTextDecoder(r) }
+
+func NewTextEncoder(w io.Writer) *TextEncoder {
+	return &TextEncoder{writer: w}
+}
+
+func escapeKey(key []string) string {
+	if len(key) == 1 && key[0] == "" {
+		return "\\_"
+	}
+
+	var b strings.Builder
+	for i, s := range key {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+
+		for _, c := range []byte(s) {
+			switch c {
+			case '\\', '.', '=', '#', '[':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case '\n':
+				b.WriteString("\\n")
+			default:
+				b.WriteByte(c)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func escapeValue(v string) string {
+	v = strings.Replace(v, "\\", "\\\\", -1)
+	return strings.Replace(v, "\n", "\\\n", -1)
+}
+
+// writes a single message with a single call to the underlying writer
+func (e *TextEncoder) Encode(m Message) error {
+	var b strings.Builder
+	if m.Comment != "" {
+		for _, l := range strings.Split(m.Comment, "\n") {
+			b.WriteByte('#')
+			if l != "" {
+				b.WriteByte(' ')
+				b.WriteString(l)
+			}
+
+			b.WriteByte('\n')
+		}
+	}
+
+	b.WriteString(escapeKey(m.Key))
+	b.WriteString(" = ")
+	b.WriteString(escapeValue(m.Val))
+	b.WriteByte('\n')
+	if b.Len() > maxTextFrame {
+		return &FrameError{Reason: "frame too large"}
+	}
+
+	_, err := io.WriteString(e.writer, b.String())
+	return err
+}
+
+func NewTextDecoder(r io.Reader) *TextDecoder {
+	return &TextDecoder{reader: bufio.NewReader(r), maxFrame: maxTextFrame}
+}
+
+func (d *TextDecoder) frameError(reason string) error {
+	return &FrameError{Line: d.line, Reason: reason}
+}
+
+// reads a full line. blocks until the line is complete, the stream ends,
+// or the lines of the current frame exceed the maximum frame size. the
+// returned line doesn't contain the closing new line.
+func (d *TextDecoder) readLine(inFrame bool) (string, error) {
+	var l []byte
+	for {
+		s, err := d.reader.ReadSlice('\n')
+		d.frame += len(s)
+		if d.frame > d.maxFrame {
+			d.line++
+			return "", d.frameError("frame too large")
+		}
+
+		l = append(l, s...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err == io.EOF && len(l) == 0 && !inFrame {
+			return "", io.EOF
+		}
+
+		d.line++
+		if err == io.EOF {
+			return "", d.frameError("unexpected end of stream")
+		} else if err != nil {
+			return "", err
+		}
+
+		return string(l[:len(l)-1]), nil
+	}
+}
+
+// splits the key from the rest of the line at the first unescaped '='
+func (d *TextDecoder) parseKey(l string) ([]string, string, error) {
+	var (
+		key     []string
+		segment []byte
+		escaped bool
+		present bool
+	)
+
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		if escaped {
+			escaped = false
+			switch c {
+			case 'n':
+				segment = append(segment, '\n')
+			case '_':
+			case '\\', '.', '=', '#', '[':
+				segment = append(segment, c)
+			default:
+				return nil, "", d.frameError("invalid escape sequence in key")
+			}
+
+			continue
+		}
+
+		switch c {
+		case '\\':
+			escaped = true
+			present = true
+		case '.':
+			key = append(key, string(segment))
+			segment = nil
+			present = true
+		case '=':
+			if len(segment) > 0 && segment[len(segment)-1] == ' ' {
+				segment = segment[:len(segment)-1]
+			}
+
+			if present || len(segment) > 0 {
+				key = append(key, string(segment))
+			}
+
+			return key, l[i+1:], nil
+		default:
+			segment = append(segment, c)
+		}
+	}
+
+	return nil, "", d.frameError("missing '=' separator")
+}
+
+// reads the value, following the line continuations
+func (d *TextDecoder) parseValue(l string) (string, error) {
+	if len(l) > 0 && l[0] == ' ' {
+		l = l[1:]
+	}
+
+	var v []byte
+	for {
+		escaped := false
+		for i := 0; i < len(l); i++ {
+			c := l[i]
+			if escaped {
+				escaped = false
+				if c != '\\' {
+					return "", d.frameError("invalid escape sequence in value")
+				}
+
+				v = append(v, c)
+				continue
+			}
+
+			if c == '\\' {
+				escaped = true
+			} else {
+				v = append(v, c)
+			}
+		}
+
+		if !escaped {
+			return string(v), nil
+		}
+
+		v = append(v, '\n')
+
+		var err error
+		l, err = d.readLine(true)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// reads the next message
+// blocks until a full message is available
+// it returns io.EOF if the stream is closed between two messages, and
+// *FrameError when the frame is malformed or the stream ends in the
+// middle of a frame.
+func (d *TextDecoder) Decode() (Message, error) {
+	var (
+		comment []string
+		inFrame bool
+	)
+
+	d.frame = 0
+	for {
+		l, err := d.readLine(inFrame)
+		if err != nil {
+			return Message{}, err
+		}
+
+		if l == "" {
+			if !inFrame {
+				d.frame = 0
+			}
+
+			continue
+		}
+
+		if l[0] == '#' {
+			l = l[1:]
+			if len(l) > 0 && l[0] == ' ' {
+				l = l[1:]
+			}
+
+			comment = append(comment, l)
+			inFrame = true
+			continue
+		}
+
+		key, rest, err := d.parseKey(l)
+		if err != nil {
+			return Message{}, err
+		}
+
+		val, err := d.parseValue(rest)
+		if err != nil {
+			return Message{}, err
+		}
+
+		return Message{
+			Key:     key,
+			Val:     val,
+			Comment: strings.Join(comment, "\n"),
+		}, nil
+	}
+}
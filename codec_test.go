@@ -0,0 +1,236 @@
+package cast
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var codecTestMessages = []Message{
+	{},
+	{Key: []string{"foo"}},
+	{Key: []string{"foo", "bar"}, Val: "baz"},
+	{Key: []string{"foo", "bar"}, Val: "baz", Comment: "qux"},
+	{Key: []string{""}},
+	{Key: []string{"", ""}},
+	{Key: []string{"foo", ""}},
+	{Key: []string{" foo ", " "}, Val: " bar "},
+	{Key: []string{"a.b", "c=d", "#e", "[f]", "g\\h", "i\nj"}},
+	{Val: "multi\nline\n\nvalue\n"},
+	{Val: "\\\n\\\\"},
+	{Val: "= # [section] \\n"},
+	{Comment: "multi\nline\n\ncomment"},
+	{Comment: "\n"},
+	{Comment: " # comment"},
+	{Val: "crlf\r\nvalue\r"},
+}
+
+func messagesEqual(left, right Message) bool {
+	if len(left.Key) != len(right.Key) {
+		return false
+	}
+
+	for i := range left.Key {
+		if left.Key[i] != right.Key[i] {
+			return false
+		}
+	}
+
+	return left.Val == right.Val && left.Comment == right.Comment
+}
+
+func testRoundTrip(t *testing.T, codec Codec, ms []Message, read func(io.Reader) io.Reader) {
+	var b bytes.Buffer
+	enc := codec.NewEncoder(&b)
+	for _, m := range ms {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := codec.NewDecoder(read(&b))
+	for _, m := range ms {
+		mr, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !messagesEqual(mr, m) {
+			t.Errorf("round trip failed: %q, %q", m, mr)
+		}
+	}
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Error("failed to report end of stream", err)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	testRoundTrip(t, TextCodec, codecTestMessages, func(r io.Reader) io.Reader { return r })
+}
+
+func TestTextPartialReads(t *testing.T) {
+	testRoundTrip(t, TextCodec, codecTestMessages, iotest.OneByteReader)
+}
+
+func TestTextFormat(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewTextEncoder(&b)
+	enc.Encode(Message{
+		Key:     []string{"service", "billing.eu"},
+		Val:     "line one\nline two",
+		Comment: "some\nnotes"})
+
+	expected := "# some\n# notes\nservice.billing\\.eu = line one\\\nline two\n"
+	if b.String() != expected {
+		t.Errorf("invalid format: %q", b.String())
+	}
+}
+
+func TestTextReadsHandWritten(t *testing.T) {
+	dec := NewTextDecoder(strings.NewReader("\n#comment\n\nfoo.bar=baz\n\nqux = quux\n"))
+
+	m, err := dec.Decode()
+	if err != nil || !messagesEqual(m, Message{Key: []string{"foo", "bar"}, Val: "baz", Comment: "comment"}) {
+		t.Error("failed to decode message", m, err)
+	}
+
+	m, err = dec.Decode()
+	if err != nil || !messagesEqual(m, Message{Key: []string{"qux"}, Val: "quux"}) {
+		t.Error("failed to decode message", m, err)
+	}
+}
+
+func TestTextMalformed(t *testing.T) {
+	for _, ti := range []struct {
+		msg, frame string
+		line       int
+	}{{
+		"missing separator", "foo\n", 1,
+	}, {
+		"invalid key escape", "foo\\bar = baz\n", 1,
+	}, {
+		"invalid value escape", "foo = b\\az\n", 1,
+	}, {
+		"missing new line", "foo = bar", 1,
+	}, {
+		"end of stream after comment", "# comment\n", 2,
+	}, {
+		"end of stream in continuation", "foo = bar\\\n", 2,
+	}, {
+		"second line", "foo = bar\nbaz\n", 2,
+	}} {
+		dec := NewTextDecoder(strings.NewReader(ti.frame))
+
+		var err error
+		for err == nil {
+			_, err = dec.Decode()
+		}
+
+		ferr, ok := err.(*FrameError)
+		if !ok {
+			t.Error(ti.msg, "failed to report frame error", err)
+			continue
+		}
+
+		if ferr.Line != ti.line {
+			t.Error(ti.msg, "invalid line reported", ferr.Line)
+		}
+	}
+}
+
+func TestTextFrameTooLarge(t *testing.T) {
+	for _, ti := range []struct {
+		msg, frame string
+		line       int
+	}{{
+		"long line", "foo = " + strings.Repeat("bar", 8) + "\n", 2,
+	}, {
+		"long comment", "# " + strings.Repeat("bar", 8) + "\nfoo = bar\n", 2,
+	}, {
+		"long continuation", "foo = bar\\\nbaz\\\nqux\\\nquux\n", 4,
+	}} {
+		dec := NewTextDecoder(strings.NewReader("foo = bar\n" + ti.frame))
+		dec.maxFrame = 16
+
+		m, err := dec.Decode()
+		if err != nil || !messagesEqual(m, Message{Key: []string{"foo"}, Val: "bar"}) {
+			t.Error(ti.msg, "failed to decode message", m, err)
+			continue
+		}
+
+		_, err = dec.Decode()
+		ferr, ok := err.(*FrameError)
+		if !ok || ferr.Reason != "frame too large" {
+			t.Error(ti.msg, "failed to report frame too large", err)
+			continue
+		}
+
+		if ferr.Line != ti.line {
+			t.Error(ti.msg, "invalid line reported", ferr.Line)
+		}
+	}
+}
+
+func addCodecSeeds(f *testing.F) {
+	for _, m := range codecTestMessages {
+		f.Add(m.Key != nil, strings.Join(m.Key, "\x00"), m.Val, m.Comment)
+	}
+}
+
+func testFuzzRoundTrip(t *testing.T, codec Codec, hasKey bool, key, val, comment string) {
+	m := Message{Val: val, Comment: comment}
+	if hasKey {
+		m.Key = strings.Split(key, "\x00")
+	}
+
+	testRoundTrip(t, codec, []Message{m, m}, iotest.HalfReader)
+}
+
+// arbitrary input should not cause a panic, and what is decoded, should
+// be encoded and decoded to the same message
+func testFuzzDecode(t *testing.T, codec Codec, data []byte) {
+	var ms []Message
+	dec := codec.NewDecoder(bytes.NewBuffer(data))
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			if _, ok := err.(*FrameError); !ok {
+				t.Fatal("unexpected error", err)
+			}
+
+			break
+		}
+
+		ms = append(ms, m)
+	}
+
+	testRoundTrip(t, codec, ms, func(r io.Reader) io.Reader { return r })
+}
+
+func FuzzTextCodec(f *testing.F) {
+	addCodecSeeds(f)
+	f.Fuzz(func(t *testing.T, hasKey bool, key, val, comment string) {
+		testFuzzRoundTrip(t, TextCodec, hasKey, key, val, comment)
+	})
+}
+
+func FuzzTextDecode(f *testing.F) {
+	var b bytes.Buffer
+	enc := NewTextEncoder(&b)
+	for _, m := range codecTestMessages {
+		enc.Encode(m)
+	}
+
+	f.Add(b.Bytes())
+	f.Add([]byte("# comment\nfoo.bar = baz\\\nqux\n\n=\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		testFuzzDecode(t, TextCodec, data)
+	})
+}
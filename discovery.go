@@ -0,0 +1,273 @@
+package cast
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	// the default period of the announcements
+	DefaultAnnounceInterval = time.Second
+
+	maxAnnouncement = 1 << 12
+)
+
+// options of the announcer
+type AnnounceOpt struct {
+
+	// the UDP address the announcements are sent to. it can be a
+	// multicast group, a broadcast or a unicast address, with a port.
+	Address string
+
+	// the URL of the announced interface, e.g. tcp://10.0.0.1:9090
+	URL string
+
+	// the period of the announcements, defaults to
+	// DefaultAnnounceInterval
+	Interval time.Duration
+}
+
+// options of the discovery
+type DiscoveryOpt struct {
+
+	// the UDP address to listen on for announcements. when it is a
+	// multicast group, the group is joined.
+	Address string
+
+	// the network interface used to join the multicast group. when
+	// nil, the system default is used.
+	Interface *net.Interface
+
+	// the time after an announced interface is removed from the list of
+	// candidates, when there is no new announcement about it. defaults
+	// to three times the DefaultAnnounceInterval.
+	Expiry time.Duration
+
+	// translates the announcement messages to interfaces, defaults to
+	// DefaultInterfaceRegistry
+	Translation InterfaceTranslation
+}
+
+// periodically announces the URL of an interface over UDP
+type Announcer struct {
+	conn   *net.UDPConn
+	frame  []byte
+	period time.Duration
+	quit   chan struct{}
+}
+
+// maintains a list of the announced interfaces
+type Discovery struct {
+	conn        *net.UDPConn
+	expiry      time.Duration
+	translation InterfaceTranslation
+	parents     chan []Interface
+	quit        chan struct{}
+}
+
+type discovered struct {
+	url      string
+	iface    Interface
+	lastSeen time.Time
+}
+
+// starts announcing the URL periodically
+func NewAnnouncer(o AnnounceOpt) (*Announcer, error) {
+	if u, err := url.Parse(o.URL); err != nil || u.Scheme == "" {
+		return nil, ErrInvalidURL
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", o.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	NewBinaryEncoder(&b).Encode(NewInterfaceMessage(o.URL))
+
+	if o.Interval <= 0 {
+		o.Interval = DefaultAnnounceInterval
+	}
+
+	a := &Announcer{
+		conn:   conn,
+		frame:  b.Bytes(),
+		period: o.Interval,
+		quit:   make(chan struct{})}
+	go a.run()
+	return a, nil
+}
+
+// sending errors are ignored, the announcement is repeated in the next
+// period
+func (a *Announcer) run() {
+	t := time.NewTicker(a.period)
+	defer t.Stop()
+	for {
+		a.conn.Write(a.frame)
+		select {
+		case <-t.C:
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// stops the announcements
+func (a *Announcer) Close() error {
+	close(a.quit)
+	return a.conn.Close()
+}
+
+// starts listening for announcements
+func NewDiscovery(o DiscoveryOpt) (*Discovery, error) {
+	addr, err := net.ResolveUDPAddr("udp", o.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", o.Interface, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Expiry <= 0 {
+		o.Expiry = 3 * DefaultAnnounceInterval
+	}
+
+	if o.Translation == nil {
+		o.Translation = DefaultInterfaceRegistry
+	}
+
+	d := &Discovery{
+		conn:        conn,
+		expiry:      o.Expiry,
+		translation: o.Translation,
+		parents:     make(chan []Interface),
+		quit:        make(chan struct{})}
+	go d.run()
+	return d, nil
+}
+
+// reads the announcements and forwards the valid ones
+func (d *Discovery) receive(announcements chan<- Message) {
+	defer close(announcements)
+	b := make([]byte, maxAnnouncement)
+	for {
+		n, _, err := d.conn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+
+		m, err := NewBinaryDecoder(bytes.NewReader(b[:n])).Decode()
+		if err != nil {
+			continue
+		}
+
+		select {
+		case announcements <- m:
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+func interfaceList(ds []*discovered) []Interface {
+	is := make([]Interface, len(ds))
+	for i, d := range ds {
+		is[i] = d.iface
+	}
+
+	return is
+}
+
+// maintains the list of the interfaces in the order of their first
+// announcement, and sends the list whenever it changes. when the list
+// is not received, only the latest version is kept.
+func (d *Discovery) run() {
+	var (
+		list   []*discovered
+		update []Interface
+		send   chan<- []Interface
+	)
+
+	defer close(d.parents)
+
+	announcements := make(chan Message)
+	go d.receive(announcements)
+
+	t := time.NewTicker(d.expiry / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case m, open := <-announcements:
+			if !open {
+				return
+			}
+
+			i := d.translation.Translate(m)
+			if i == nil {
+				continue
+			}
+
+			var found bool
+			for _, di := range list {
+				if di.url == m.Val {
+					di.lastSeen = time.Now()
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				list = append(list, &discovered{url: m.Val, iface: i, lastSeen: time.Now()})
+				update, send = interfaceList(list), d.parents
+			}
+		case now := <-t.C:
+			var (
+				current []*discovered
+				changed bool
+			)
+
+			for _, di := range list {
+				if now.Sub(di.lastSeen) < d.expiry {
+					current = append(current, di)
+				} else {
+					changed = true
+				}
+			}
+
+			list = current
+			if changed {
+				update, send = interfaceList(list), d.parents
+			}
+		case send <- update:
+			update, send = nil, nil
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// receives the list of the announced interfaces, every time it changes
+func (d *Discovery) Parents() <-chan []Interface { return d.parents }
+
+// stops listening to announcements, and closes the parents channel
+func (d *Discovery) Close() error {
+	close(d.quit)
+	return d.conn.Close()
+}
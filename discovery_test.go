@@ -0,0 +1,145 @@
+package cast
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func freeUDPAddress(t *testing.T) string {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+	return c.LocalAddr().String()
+}
+
+func receiveParents(t *testing.T, d *Discovery, count int) []Interface {
+	var parents []Interface
+	testTimeout(t, func() {
+		for len(parents) != count {
+			parents = <-d.Parents()
+		}
+	})
+
+	return parents
+}
+
+func TestDiscovery(t *testing.T) {
+	address := freeUDPAddress(t)
+	d, err := NewDiscovery(DiscoveryOpt{Address: address, Expiry: 60 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.Close()
+
+	a1, err := NewAnnouncer(AnnounceOpt{
+		Address:  address,
+		URL:      "tcp://127.0.0.1:9090",
+		Interval: 6 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a1.Close()
+
+	parents := receiveParents(t, d, 1)
+	if i, ok := parents[0].(TCPInterface); !ok || i.Address != "127.0.0.1:9090" {
+		t.Error("invalid interface", parents[0])
+	}
+
+	a2, err := NewAnnouncer(AnnounceOpt{
+		Address:  address,
+		URL:      "unix:///tmp/cast.sock",
+		Interval: 6 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parents = receiveParents(t, d, 2)
+	if i, ok := parents[1].(UnixInterface); !ok || i.Path != "/tmp/cast.sock" {
+		t.Error("invalid interface", parents[1])
+	}
+
+	a2.Close()
+	parents = receiveParents(t, d, 1)
+	if _, ok := parents[0].(TCPInterface); !ok {
+		t.Error("invalid interface", parents[0])
+	}
+}
+
+func TestDiscoveryMulticastLoopback(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil || lo.Flags&net.FlagMulticast == 0 {
+		t.Skip("no multicast loopback interface")
+	}
+
+	d, err := NewDiscovery(DiscoveryOpt{Address: "239.255.42.99:42099", Interface: lo})
+	if err != nil {
+		t.Skip(err)
+	}
+
+	defer d.Close()
+
+	a, err := NewAnnouncer(AnnounceOpt{
+		Address:  "239.255.42.99:42099",
+		URL:      "tcp://127.0.0.1:9090",
+		Interval: 6 * time.Millisecond})
+	if err != nil {
+		t.Skip(err)
+	}
+
+	defer a.Close()
+	receiveParents(t, d, 1)
+}
+
+func TestRecoveryNodeDiscovery(t *testing.T) {
+	l, err := NewTCPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	p := NewNode(0, 0)
+	p.Listen(l)
+
+	address := freeUDPAddress(t)
+	d, err := NewDiscovery(DiscoveryOpt{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer d.Close()
+
+	rn := NewRecoveryNode(RecoveryOpt{Discovery: d})
+
+	a, err := NewAnnouncer(AnnounceOpt{
+		Address:  address,
+		URL:      "tcp://" + l.Addr().String(),
+		Interval: 6 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Close()
+
+	testTimeout(t, func() {
+		for {
+			select {
+			case rn.Send() <- Message{}:
+			case <-p.Receive():
+				return
+			}
+		}
+	})
+}
+
+func TestAnnouncerInvalidURL(t *testing.T) {
+	if _, err := NewAnnouncer(AnnounceOpt{Address: "127.0.0.1:9090"}); err != ErrInvalidURL {
+		t.Error("failed to fail", err)
+	}
+}
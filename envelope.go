@@ -0,0 +1,198 @@
+package cast
+
+import (
+	"strconv"
+	"strings"
+)
+
+// the direction in which a message is forwarded in the tree
+type Scope int
+
+const (
+	// the message is forwarded both to the parent and the children
+	ScopeBoth Scope = iota
+
+	// the message is forwarded only toward the root, to the parent
+	ScopeUp
+
+	// the message is forwarded only toward the leaves, to the children
+	ScopeDown
+)
+
+// metadata carried with the messages between the nodes
+//
+// on the wire, the envelope is represented as a key prefix: the
+// segments 'cast' and 'envelope', followed by a 'name=value' segment
+// for each field, closed by an empty segment, followed by the original
+// key. unknown fields are ignored. the envelope is sent only to the
+// connections known to be nodes, see nodeTransport. the messages received
+// from the other connections are stamped by the receiving node as if they
+// originated from it.
+//
+// the value and the comment of the message are not changed.
+//
+// the application can set the fields that control the delivery of a
+// message with the With* functions. the origin and the sequence number
+// are always set by the node.
+type envelope struct {
+	origin string
+	seq    uint64
+
+	// the number of hops the message can still travel, 0 means no
+	// limit
+	ttl int
+
+	// the direction of the message
+	scope Scope
+
+	// the id of the node the message is routed to, empty for the
+	// messages sent to all the nodes
+	to string
+
+	// the type of the control messages exchanged between the nodes,
+	// empty for the application messages
+	control string
+}
+
+var envelopeKey = []string{"cast", "envelope"}
+
+// appends the fields of the envelope to f
+func (e *envelope) appendFields(f []string) []string {
+	if e.origin != "" {
+		f = append(f, "origin="+e.origin)
+	}
+
+	if e.seq > 0 {
+		f = append(f, "seq="+strconv.FormatUint(e.seq, 10))
+	}
+
+	if e.ttl > 0 {
+		f = append(f, "ttl="+strconv.Itoa(e.ttl))
+	}
+
+	switch e.scope {
+	case ScopeUp:
+		f = append(f, "scope=up")
+	case ScopeDown:
+		f = append(f, "scope=down")
+	}
+
+	if e.to != "" {
+		f = append(f, "to="+e.to)
+	}
+
+	if e.control != "" {
+		f = append(f, "control="+e.control)
+	}
+
+	return f
+}
+
+func (e *envelope) setField(name, value string) {
+	switch name {
+	case "origin":
+		e.origin = value
+	case "seq":
+		e.seq, _ = strconv.ParseUint(value, 10, 64)
+	case "ttl":
+		if ttl, err := strconv.Atoi(value); err == nil && ttl > 0 {
+			e.ttl = ttl
+		}
+	case "scope":
+		switch value {
+		case "up":
+			e.scope = ScopeUp
+		case "down":
+			e.scope = ScopeDown
+		}
+	case "to":
+		e.to = value
+	case "control":
+		e.control = value
+	}
+}
+
+// returns the message with the envelope in the key
+func wrap(m Message, e *envelope) Message {
+	// there are at most six fields
+	key := make([]string, 0, len(envelopeKey)+7+len(m.Key))
+	key = append(key, envelopeKey...)
+	key = e.appendFields(key)
+	key = append(key, "")
+	m.Key = append(key, m.Key...)
+	return m
+}
+
+// returns the original message and the envelope. when the message
+// doesn't have an envelope, it returns nil as the envelope.
+func unwrap(m Message) (Message, *envelope) {
+	if len(m.Key) <= len(envelopeKey) ||
+		m.Key[0] != envelopeKey[0] ||
+		m.Key[1] != envelopeKey[1] {
+		return m, nil
+	}
+
+	e := &envelope{}
+	for i, s := range m.Key[len(envelopeKey):] {
+		if s == "" {
+			if key := m.Key[len(envelopeKey)+i+1:]; len(key) > 0 {
+				m.Key = key
+			} else {
+				m.Key = nil
+			}
+
+			return m, e
+		}
+
+		if name, value, ok := strings.Cut(s, "="); ok {
+			e.setField(name, value)
+		}
+	}
+
+	return m, nil
+}
+
+// returns the envelope already set on the message by the application,
+// or a new one
+func applicationEnvelope(m Message) (Message, *envelope) {
+	m, e := unwrap(m)
+	if e == nil {
+		e = &envelope{}
+	}
+
+	return m, e
+}
+
+// sets the number of hops that the message can travel from the sending
+// node. 1 means that it reaches only the parent and the children of the
+// node. 0 means no limit.
+func WithTTL(m Message, ttl int) Message {
+	m, e := applicationEnvelope(m)
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	e.ttl = ttl
+	return wrap(m, e)
+}
+
+// sets the direction in which the message is forwarded. with ScopeUp, the
+// message reaches only the nodes on the path to the root, with ScopeDown,
+// only the subtree of the sending node.
+func WithScope(m Message, s Scope) Message {
+	m, e := applicationEnvelope(m)
+	e.scope = s
+	return wrap(m, e)
+}
+
+// tells whether the delivery of the message depends on the envelope
+func (e *envelope) routed() bool {
+	return e != nil && (e.to != "" || e.scope != ScopeBoth || e.ttl > 0)
+}
+
+// sets the id of the node that the message is routed to
+func withDestination(m Message, id string) Message {
+	m, e := applicationEnvelope(m)
+	e.to = id
+	return wrap(m, e)
+}
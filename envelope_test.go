@@ -0,0 +1,145 @@
+package cast
+
+import "testing"
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, m := range []Message{
+		{},
+		{Key: []string{"foo", "bar"}, Val: "baz", Comment: "qux"},
+		{Key: []string{"", "foo", ""}},
+		{Key: []string{"cast", "envelope", "origin=foo", ""}},
+	} {
+		w := wrap(m, &envelope{origin: "foo", seq: 42})
+		mu, e := unwrap(w)
+		if e == nil || e.origin != "foo" || e.seq != 42 {
+			t.Error("failed to unwrap envelope", e)
+			continue
+		}
+
+		if !messagesEqual(mu, m) {
+			t.Error("failed to unwrap message", m, mu)
+		}
+	}
+}
+
+func TestEnvelopeMissing(t *testing.T) {
+	for _, m := range []Message{
+		{},
+		{Key: []string{"foo"}},
+		{Key: []string{"cast", "envelope"}},
+		{Key: []string{"cast", "envelope", "origin=foo"}},
+	} {
+		mu, e := unwrap(m)
+		if e != nil {
+			t.Error("unexpected envelope", m.Key)
+		}
+
+		if !messagesEqual(mu, m) {
+			t.Error("message changed", m, mu)
+		}
+	}
+}
+
+func TestEnvelopeIgnoresUnknownFields(t *testing.T) {
+	m, e := unwrap(Message{Key: []string{"cast", "envelope", "foo=bar", "origin=baz", "qux", "", "quux"}})
+	if e == nil || e.origin != "baz" || len(m.Key) != 1 || m.Key[0] != "quux" {
+		t.Error("failed to unwrap message", m, e)
+	}
+}
+
+func TestNodeToNodeKeepsKey(t *testing.T) {
+	nodes := createChain(3)
+	testTimeout(t, func() {
+		nodes[0].Send() <- Message{Key: []string{"foo", "bar"}}
+		for _, n := range nodes[1:] {
+			m := <-n.Receive()
+			if len(m.Key) != 2 || m.Key[0] != "foo" || m.Key[1] != "bar" {
+				t.Error("invalid key", m.Key)
+			}
+		}
+	})
+}
+
+func TestEnvelopeTTL(t *testing.T) {
+	m := WithTTL(WithTTL(Message{Key: []string{"foo"}}, 3), 2)
+	mu, e := unwrap(m)
+	if e == nil || e.ttl != 2 || len(mu.Key) != 1 || mu.Key[0] != "foo" {
+		t.Error("failed to set ttl", mu, e)
+	}
+
+	mu, e = unwrap(WithTTL(m, 0))
+	if e == nil || e.ttl != 0 || len(e.appendFields(nil)) != 0 {
+		t.Error("failed to reset ttl", mu, e)
+	}
+}
+
+func TestConnectionReceivesPlainKey(t *testing.T) {
+	n, _, _, children := createTestNode(0, 0, false, 1)
+	defer close(n.Send())
+	testTimeout(t, func() {
+		n.Send() <- Message{Key: []string{"foo", "bar"}}
+		m := <-children[0].Receive()
+		if len(m.Key) != 2 || m.Key[0] != "foo" || m.Key[1] != "bar" {
+			t.Error("invalid key", m.Key)
+		}
+	})
+}
+
+func TestConnectionControlIgnored(t *testing.T) {
+	n, _, _, children := createTestNode(0, 0, false, 2)
+	defer close(n.Send())
+	testTimeout(t, func() {
+		children[0].Send() <- wrap(
+			Message{Val: encodeInterests(interests{{"bar"}})},
+			&envelope{control: controlInterests})
+		children[0].Send() <- Message{Val: "foo"}
+		if m := <-children[1].Receive(); m.Val != "foo" {
+			t.Error("unexpected message", m)
+		}
+
+		<-n.Receive()
+		n.Send() <- Message{Key: []string{"baz"}}
+		if m := <-children[0].Receive(); len(m.Key) != 1 || m.Key[0] != "baz" {
+			t.Error("unexpected message", m)
+		}
+	})
+}
+
+func TestConnectionEnvelopeStripped(t *testing.T) {
+	n, _, _, children := createTestNode(0, 0, false, 2)
+	defer close(n.Send())
+	testTimeout(t, func() {
+		for i := 0; i < 2; i++ {
+			children[0].Send() <- wrap(Message{Val: "foo"}, &envelope{origin: "bar", seq: 1})
+			if m := <-n.Receive(); m.Val != "foo" || len(m.Key) != 0 {
+				t.Error("unexpected message", m)
+			}
+
+			if m := <-children[1].Receive(); m.Val != "foo" || len(m.Key) != 0 {
+				t.Error("unexpected message", m)
+			}
+		}
+	})
+}
+
+func TestRoutedMessageNotSentToConnection(t *testing.T) {
+	n, parent, _, children := createTestNode(0, 0, true, 1)
+	defer close(n.Send())
+	testTimeout(t, func() {
+		n.Send() <- WithScope(Message{Val: "foo"}, ScopeDown)
+		n.Send() <- WithTTL(Message{Val: "bar"}, 2)
+		n.(Router).SendTo("baz", Message{Val: "qux"})
+		n.Send() <- Message{Val: "quux"}
+		if m := <-children[0].Receive(); m.Val != "quux" {
+			t.Error("unexpected message", m)
+		}
+
+		if m := <-parent.Receive(); m.Val != "quux" {
+			t.Error("unexpected message", m)
+		}
+	})
+
+	if s := n.(Monitor).Stats(); s.DroppedNonNode != 4 {
+		t.Error("invalid count of the dropped messages", s.DroppedNonNode)
+	}
+}
@@ -0,0 +1,65 @@
+package cast
+
+import "sync/atomic"
+
+// the number of errors buffered by a node by default, until they are
+// received
+const DefaultErrorBuffer = 64
+
+// the kind of an error reported by a node
+type ErrorKind int
+
+const (
+	// the parent was disconnected, the error is ErrDisconnected
+	ErrorDisconnected ErrorKind = iota
+
+	// the listener was closed, the error is ErrListenerDisconnected
+	ErrorListenerDisconnected
+
+	// a message timed out on a connection, or in the store of a
+	// recovery node, the error is a *TimeoutError
+	ErrorTimeout
+
+	// a message was dropped by the overflow policy of a connection, or
+	// from the store of a recovery node, the error is an *OverflowError
+	ErrorOverflow
+
+	// nothing was received from a connection known to be a node during
+	// the heartbeat timeout, and it was closed. the error is
+	// ErrHeartbeatTimeout.
+	ErrorHeartbeatTimeout
+
+	// a recovery node failed to connect to any of the candidate parents
+	// during the recovery timeout, the error is ErrRecoveryFailed
+	ErrorRecoveryFailed
+)
+
+// error reported by a node
+type NodeError struct {
+	Kind ErrorKind
+
+	// the connection affected, as it was passed to Join or received
+	// from the listener. nil when the error is about the node's own
+	// connection or the listener.
+	Connection Connection
+
+	// the key of the message affected, if any
+	Key []string
+
+	// the underlying error, it can be checked with errors.Is and
+	// errors.As
+	Err error
+}
+
+func (e *NodeError) Error() string { return e.Err.Error() }
+func (e *NodeError) Unwrap() error { return e.Err }
+
+// sends the error without blocking, or counts it as dropped, when the
+// buffer of the error channel is full
+func reportError(err chan<- error, dropped *atomic.Uint64, e error) {
+	select {
+	case err <- e:
+	default:
+		dropped.Add(1)
+	}
+}
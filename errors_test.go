@@ -0,0 +1,73 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func receiveNodeError(t *testing.T, n Node) *NodeError {
+	var ne *NodeError
+	testTimeout(t, func() {
+		if !errors.As(<-n.Error(), &ne) {
+			t.Fatal("invalid error")
+		}
+	})
+
+	return ne
+}
+
+func TestNodeErrorDisconnected(t *testing.T) {
+	n := NewNode(0, 0)
+	local, p := NewInProcConnection()
+	n.Join(p)
+	close(local.Send())
+
+	ne := receiveNodeError(t, n)
+	if ne.Kind != ErrorDisconnected || ne.Connection != p || !errors.Is(ne, ErrDisconnected) {
+		t.Error("invalid error", ne)
+	}
+}
+
+func TestNodeErrorTimeout(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{MessageTimeout: 15 * time.Millisecond})
+	go receiveAll(n)
+
+	l := make(InProcListener)
+	n.Listen(l)
+	_, child := NewInProcConnection()
+	l <- child
+
+	n.Send() <- Message{Key: []string{"foo"}}
+	ne := receiveNodeError(t, n)
+	var terr *TimeoutError
+	if ne.Kind != ErrorTimeout || ne.Connection != child ||
+		len(ne.Key) != 1 || ne.Key[0] != "foo" || !errors.As(ne, &terr) {
+		t.Error("invalid error", ne)
+	}
+}
+
+func TestDroppedErrors(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{ErrorBuffer: 1})
+	for i := 0; i < 3; i++ {
+		local, p := NewInProcConnection()
+		n.Join(p)
+		close(local.Send())
+		expectEvent(t, n, ParentChanged, p, nil)
+		expectEvent(t, n, ParentChanged, nil, p)
+	}
+
+	if s := n.(Monitor).Stats(); s.DroppedErrors != 2 {
+		t.Error("failed to count dropped errors", s.DroppedErrors)
+	}
+
+	if ne := receiveNodeError(t, n); ne.Kind != ErrorDisconnected {
+		t.Error("invalid error", ne)
+	}
+
+	select {
+	case err := <-n.Error():
+		t.Error("unexpected error", err)
+	default:
+	}
+}
@@ -0,0 +1,130 @@
+package cast
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// the default number of events buffered by a node
+const DefaultEventBuffer = 64
+
+// the type of a membership change of a node
+type EventType int
+
+const (
+	// a child connection was received from the listener
+	ChildConnected EventType = iota
+
+	// a child connection was closed, or it was closed by the node,
+	// because the listener was closed
+	ChildDisconnected
+
+	// the parent was set with Join, replacing the previous one when
+	// there was one, or the parent was disconnected, in which case the
+	// connection of the event is nil
+	ParentChanged
+
+	// a recovery node failed to connect to a candidate parent
+	ReconnectFailed
+
+	// a recovery node connected to the candidate parent selected by its
+	// strategy
+	ParentSelected
+
+	// a recovery node with StrategyFailBack connected to its original
+	// parent again, replacing the current one
+	FailBack
+)
+
+// reports a change of the connections of a node
+type Event struct {
+	Type EventType
+
+	// the connection that the event is about, as it was passed to Join
+	// or received from the listener
+	Connection Connection
+
+	// the address of the remote end of the connection, when the
+	// transport knows it, otherwise nil
+	RemoteAddr net.Addr
+
+	// the previous parent in case of ParentChanged, nil when the node
+	// didn't have a parent
+	Previous Connection
+
+	// the candidate parent and the error of a failed connection attempt
+	// in case of ReconnectFailed, or the selected parent in case of
+	// ParentSelected and FailBack
+	Interface Interface
+	Err       error
+
+	// the strategy that selected the parent in case of ParentSelected
+	// and FailBack
+	Strategy ParentStrategy
+
+	// the connection time measured by the probe in case of
+	// ParentSelected with StrategyLeastLatency
+	Latency time.Duration
+
+	// the delay before the next attempt in case of ReconnectFailed. zero
+	// when the next candidate parent is tried immediately, or when the
+	// recovery gave up.
+	Backoff time.Duration
+}
+
+// implemented by the connections and the streams whose transport knows
+// the address of the remote end
+type remoteAddrSource interface {
+	RemoteAddr() net.Addr
+}
+
+func (t EventType) String() string {
+	switch t {
+	case ChildConnected:
+		return "child connected"
+	case ChildDisconnected:
+		return "child disconnected"
+	case ParentChanged:
+		return "parent changed"
+	case ReconnectFailed:
+		return "reconnect failed"
+	case ParentSelected:
+		return "parent selected"
+	case FailBack:
+		return "fail back"
+	default:
+		return "unknown"
+	}
+}
+
+func remoteAddr(c Connection) net.Addr {
+	if oc, ok := c.(overflowConnection); ok {
+		c = oc.Connection
+	}
+
+	if rc, ok := c.(remoteAddrSource); ok {
+		return rc.RemoteAddr()
+	}
+
+	return nil
+}
+
+// sends the event without blocking, or counts it as dropped when the
+// buffer is full
+func reportEvent(events chan<- Event, dropped *atomic.Uint64, e Event) {
+	select {
+	case events <- e:
+	default:
+		dropped.Add(1)
+	}
+}
+
+func newEvent(t EventType, c Connection) Event {
+	e := Event{Type: t, Connection: c}
+	if c != nil {
+		e.RemoteAddr = remoteAddr(c)
+	}
+
+	return e
+}
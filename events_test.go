@@ -0,0 +1,121 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func expectEvent(t *testing.T, n Node, typ EventType, c, previous Connection) {
+	testTimeout(t, func() {
+		e := <-n.(EventSource).Events()
+		if e.Type != typ || e.Connection != c || e.Previous != previous {
+			t.Error("invalid event", e.Type, typ)
+		}
+	})
+}
+
+func TestChildEvents(t *testing.T) {
+	n := NewNode(0, 0)
+	l := make(InProcListener)
+	n.Listen(l)
+
+	local1, remote1 := NewInProcConnection()
+	local2, remote2 := NewInProcConnection()
+	l <- remote1
+	l <- remote2
+	expectEvent(t, n, ChildConnected, remote1, nil)
+	expectEvent(t, n, ChildConnected, remote2, nil)
+
+	close(local1.Send())
+	expectEvent(t, n, ChildDisconnected, remote1, nil)
+
+	close(l)
+	expectEvent(t, n, ChildDisconnected, remote2, nil)
+	testTimeout(t, func() {
+		if _, open := <-local2.Receive(); open {
+			t.Error("failed to close child")
+		}
+	})
+}
+
+func TestParentEvents(t *testing.T) {
+	n := NewNode(0, 0)
+
+	_, p1 := NewInProcConnection()
+	n.Join(p1)
+	expectEvent(t, n, ParentChanged, p1, nil)
+
+	local2, p2 := NewInProcConnection()
+	n.Join(p2)
+	expectEvent(t, n, ParentChanged, p2, p1)
+
+	close(local2.Send())
+	expectEvent(t, n, ParentChanged, nil, p2)
+	testTimeout(t, func() {
+		if err := <-n.Error(); !errors.Is(err, ErrDisconnected) {
+			t.Error("unexpected error", err)
+		}
+	})
+}
+
+func TestEventRemoteAddr(t *testing.T) {
+	parent, child, l := createTCPPair(t)
+	defer l.Close()
+
+	testTimeout(t, func() {
+		pe := <-parent.(EventSource).Events()
+		ce := <-child.(EventSource).Events()
+		if pe.Type != ChildConnected || ce.Type != ParentChanged {
+			t.Fatal("invalid events", pe.Type, ce.Type)
+		}
+
+		if ce.RemoteAddr == nil || ce.RemoteAddr.String() != l.Addr().String() {
+			t.Error("invalid remote address", ce.RemoteAddr)
+		}
+
+		if pe.RemoteAddr == nil || pe.RemoteAddr.String() == ce.RemoteAddr.String() {
+			t.Error("invalid remote address", pe.RemoteAddr)
+		}
+	})
+}
+
+func TestEventNoRemoteAddr(t *testing.T) {
+	n := NewNode(0, 0)
+	_, p := NewInProcConnection()
+	n.Join(WithOverflow(p, OverflowDropOldest))
+	testTimeout(t, func() {
+		if e := <-n.(EventSource).Events(); e.RemoteAddr != nil {
+			t.Error("unexpected remote address", e.RemoteAddr)
+		}
+	})
+}
+
+func TestDroppedEvents(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{EventBuffer: 1})
+	defer close(n.Send())
+	l := make(InProcListener)
+	n.Listen(l)
+
+	for i := 0; i < 3; i++ {
+		l.Connect()
+	}
+
+	testTimeout(t, func() {
+		for n.(Monitor).Stats().DroppedEvents != 2 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	testTimeout(t, func() {
+		if e := <-n.(EventSource).Events(); e.Type != ChildConnected {
+			t.Error("invalid event", e.Type)
+		}
+	})
+
+	select {
+	case e := <-n.(EventSource).Events():
+		t.Error("unexpected event", e.Type)
+	default:
+	}
+}
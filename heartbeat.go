@@ -0,0 +1,55 @@
+package cast
+
+import "time"
+
+// sent periodically by a node to its parent and its children that are
+// known to be nodes
+const controlHeartbeat = "heartbeat"
+
+// the time of the last message received from the connections that are
+// known to be nodes. a nil tracker means that the heartbeats are
+// disabled.
+type heartbeats struct {
+	interval time.Duration
+	timeout  time.Duration
+	lastSeen map[nodeConn]time.Time
+}
+
+func newHeartbeats(interval, timeout time.Duration) *heartbeats {
+	if interval <= 0 {
+		return nil
+	}
+
+	if timeout <= 0 {
+		timeout = 3 * interval
+	}
+
+	return &heartbeats{
+		interval: interval,
+		timeout:  timeout,
+		lastSeen: make(map[nodeConn]time.Time)}
+}
+
+func (h *heartbeats) seen(c nodeConn, now time.Time) {
+	if h != nil {
+		h.lastSeen[c] = now
+	}
+}
+
+func (h *heartbeats) remove(c nodeConn) {
+	if h != nil {
+		delete(h.lastSeen, c)
+	}
+}
+
+// returns the connections that didn't send anything during the timeout
+func (h *heartbeats) expired(now time.Time) []nodeConn {
+	var expired []nodeConn
+	for c, t := range h.lastSeen {
+		if now.Sub(t) > h.timeout {
+			expired = append(expired, c)
+		}
+	}
+
+	return expired
+}
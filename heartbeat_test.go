@@ -0,0 +1,169 @@
+package cast
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testHeartbeatInterval = 3 * time.Millisecond
+	testHeartbeatTimeout  = 30 * time.Millisecond
+)
+
+// returns a connection to a listener that never sends anything
+type hungInterface struct{}
+
+func (hungInterface) Connect() (Connection, error) {
+	l := make(InProcListener)
+	go func() {
+		c := <-l
+		for range c.Receive() {
+			time.Sleep(testHeartbeatTimeout)
+		}
+	}()
+
+	return l.Connect()
+}
+
+func heartbeatOpt() NodeOpt {
+	return NodeOpt{
+		HeartbeatInterval: testHeartbeatInterval,
+		HeartbeatTimeout:  testHeartbeatTimeout}
+}
+
+func TestHeartbeatKeepsIdleConnection(t *testing.T) {
+	parent := NewNodeWithOptions(heartbeatOpt())
+	l := make(InProcListener)
+	parent.Listen(l)
+
+	child := NewNodeWithOptions(heartbeatOpt())
+	c, _ := l.Connect()
+	child.Join(c)
+
+	defer close(parent.Send())
+	defer close(child.Send())
+
+	time.Sleep(3 * testHeartbeatTimeout)
+	expectNoMessage(t, parent)
+	expectNoMessage(t, child)
+
+	testTimeout(t, func() {
+		parent.Send() <- Message{Val: "foo"}
+		if m := <-child.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+
+	select {
+	case err := <-child.Error():
+		t.Error("unexpected error", err)
+	case err := <-parent.Error():
+		t.Error("unexpected error", err)
+	default:
+	}
+}
+
+func TestHeartbeatClosesHungParent(t *testing.T) {
+	n := NewNodeWithOptions(heartbeatOpt())
+	defer close(n.Send())
+	c, _ := hungInterface{}.Connect()
+	n.Join(c)
+
+	ne := receiveNodeError(t, n)
+	if ne.Kind != ErrorHeartbeatTimeout || ne.Connection != c ||
+		!errors.Is(ne, ErrHeartbeatTimeout) {
+		t.Error("invalid error", ne)
+	}
+
+	if ne := receiveNodeError(t, n); ne.Kind != ErrorDisconnected {
+		t.Error("invalid error", ne)
+	}
+}
+
+func TestHeartbeatIgnoresConnections(t *testing.T) {
+	n := NewNodeWithOptions(heartbeatOpt())
+	defer close(n.Send())
+	l := make(InProcListener)
+	n.Listen(l)
+	c, _ := l.Connect()
+
+	time.Sleep(2 * testHeartbeatTimeout)
+	expectNoMessage(t, c)
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		if m := <-c.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestRecoveryAfterHeartbeatTimeout(t *testing.T) {
+	p := NewNodeWithOptions(heartbeatOpt())
+	defer close(p.Send())
+	l := make(InProcListener)
+	p.Listen(l)
+
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:           []Interface{hungInterface{}, l},
+		HeartbeatInterval: testHeartbeatInterval,
+		HeartbeatTimeout:  testHeartbeatTimeout})
+
+	testTimeout(t, func() {
+		for _, connected := range []bool{true, false, true} {
+			e := <-n.(EventSource).Events()
+			for e.Type == ParentSelected {
+				e = <-n.(EventSource).Events()
+			}
+
+			if e.Type != ParentChanged || (e.Connection != nil) != connected {
+				t.Error("invalid event", e.Type)
+			}
+		}
+	})
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		if m := <-p.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestHeartbeatWhileShuttingDown(t *testing.T) {
+	o := heartbeatOpt()
+	o.MessageBuffer = 1
+	parent := NewNodeWithOptions(o)
+	l := make(InProcListener)
+	parent.Listen(l)
+
+	child := NewNodeWithOptions(heartbeatOpt())
+	defer close(child.Send())
+	c, _ := l.Connect()
+	child.Join(c)
+
+	// the message stays pending on the connection that doesn't receive
+	l.Connect()
+	testTimeout(t, func() {
+		parent.Send() <- Message{}
+		<-child.Receive()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*testHeartbeatTimeout)
+	defer cancel()
+	if _, err := parent.(Shutdowner).Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error("invalid shutdown result", err)
+	}
+
+	for {
+		select {
+		case err := <-parent.Error():
+			if errors.Is(err, ErrHeartbeatTimeout) {
+				t.Error("unexpected error", err)
+			}
+		default:
+			return
+		}
+	}
+}
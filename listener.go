@@ -0,0 +1,130 @@
+package cast
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// the time allowed for the codec handshake on a new socket
+const handshakeTimeout = 30 * time.Second
+
+// common implementation of the socket based listeners
+type streamListener struct {
+	listener    net.Listener
+	codecs      []Codec
+	connections chan Connection
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func handshake(
+	c net.Conn,
+	codecs []Codec,
+	negotiate func(io.ReadWriter, []Codec) (Codec, error),
+	connecting bool) (Connection, error) {
+
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	codec, err := negotiate(c, codecs)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.SetDeadline(time.Time{})
+	return newStreamConnection(c, codec, connecting, connecting), nil
+}
+
+// dials a socket, and negotiates the codec
+// when no codecs are set, the text codec is used
+func dialStream(network, address string, codecs []Codec) (Connection, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(codecs) == 0 {
+		codecs = []Codec{TextCodec}
+	}
+
+	return handshake(c, codecs, proposeCodec, true)
+}
+
+// takes over the net listener, and starts accepting connections
+// when no codecs are set, both the text and the binary codecs are
+// accepted
+func newStreamListener(nl net.Listener, codecs []Codec) *streamListener {
+	if len(codecs) == 0 {
+		codecs = []Codec{TextCodec, BinaryCodec}
+	}
+
+	l := &streamListener{
+		listener:    nl,
+		codecs:      codecs,
+		connections: make(chan Connection),
+		closed:      make(chan struct{})}
+	go l.accept()
+	return l
+}
+
+// the handshake of each socket is executed in its own goroutine, to
+// avoid blocking the listener by a connecting side that doesn't respond
+func (l *streamListener) accept() {
+	var wg sync.WaitGroup
+	defer func() {
+		l.Close()
+		wg.Wait()
+		close(l.connections)
+	}()
+
+	for {
+		c, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-l.closed:
+					c.Close()
+				case <-done:
+				}
+			}()
+
+			sc, err := handshake(c, l.codecs, acceptCodec, false)
+			close(done)
+			if err != nil {
+				return
+			}
+
+			select {
+			case l.connections <- sc:
+			case <-l.closed:
+				close(sc.Send())
+			}
+		}()
+	}
+}
+
+func (l *streamListener) Connections() <-chan Connection { return l.connections }
+
+// the address the listener accepts connections on
+func (l *streamListener) Addr() net.Addr { return l.listener.Addr() }
+
+// stops accepting connections and closes the connections channel
+// connections already accepted are not closed
+func (l *streamListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.listener.Close()
+	})
+
+	return err
+}
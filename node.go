@@ -1,6 +1,13 @@
 package cast
 
-import "time"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type connControlType int
 
@@ -13,11 +20,14 @@ const (
 type nodeControlType int
 
 const (
-	outgoingTimeout nodeControlType = iota
-	connOutgoingDone
+	connOutgoingDone nodeControlType = iota
 	nodeConnClosed
+	nodeConnPeer
 	joinParent
 	listenChildren
+	subscribe
+	unsubscribe
+	shutdown
 )
 
 type connControl struct {
@@ -33,23 +43,183 @@ type nodeControl struct {
 	nodeConn nodeConn
 	listener Listener
 	conn     Connection
+	key      []string
+	ctx      context.Context
+	result   chan<- shutdownResult
+}
+
+type shutdownResult struct {
+	abandoned int
+	err       error
 }
 
 type incomingMessage struct {
-	source  nodeConn
-	message *Message
+	source   nodeConn
+	message  *Message
+	envelope *envelope
+
+	// the message reached the hop limit, and it is delivered only to
+	// the node's own connection
+	expired bool
+
+	// the message was received from a connection known to be a node
+	peer bool
 }
 
+// the message is sent to the node's own connection, and to the
+// connections that are not known to be nodes, without the envelope, and
+// to the nodes with the envelope. the same outgoing message is queued by
+// every target connection, and the first connection process sending it to
+// a node adds the envelope, so it is not changed after the message was
+// dispatched.
 type outgoingMessage struct {
-	message *Message
-	conns   []nodeConn
-	discard chan struct{}
+	message  *Message
+	envelope *envelope
+	wire     *wireMessage
+
+	// zero when the message doesn't time out
+	deadline time.Time
+
+	// control messages don't time out, and they are not subject to the
+	// overflow policies
+	control bool
+}
+
+type wireMessage struct {
+	once    sync.Once
+	message Message
+}
+
+func (om *outgoingMessage) wireMessage() Message {
+	om.wire.once.Do(func() { om.wire.message = wrap(*om.message, om.envelope) })
+	return om.wire.message
 }
 
 type node struct {
+	id      string
 	extern  Connection
 	control chan *nodeControl
 	err     chan error
+	events  chan Event
+	stats   *nodeStats
+	done    chan struct{}
+}
+
+// counters of a node
+type NodeStats struct {
+
+	// the number of messages not forwarded, because they reached the
+	// hop limit set with WithTTL
+	DroppedTTL uint64
+
+	// the number of messages sent with SendTo that were dropped,
+	// because no route was known to the destination node
+	DroppedUnreachable uint64
+
+	// the number of messages dropped from a connection by its overflow
+	// policy
+	DroppedOverflow uint64
+
+	// the number of messages not sent to a connection that is not known
+	// to be a node, because their delivery depends on the envelope
+	DroppedNonNode uint64
+
+	// the number of errors not reported, because the buffer of the
+	// error channel was full
+	DroppedErrors uint64
+
+	// the number of events not reported, because the buffer of the
+	// event channel was full
+	DroppedEvents uint64
+}
+
+type nodeStats struct {
+	droppedTTL         atomic.Uint64
+	droppedUnreachable atomic.Uint64
+	droppedOverflow    atomic.Uint64
+	droppedNonNode     atomic.Uint64
+	droppedErrors      atomic.Uint64
+	droppedEvents      atomic.Uint64
+}
+
+// options of a node
+type NodeOpt struct {
+
+	// the number of messages that can be pending on a connection,
+	// before its overflow policy is applied
+	MessageBuffer int
+
+	// the time while a message can be pending on a connection. when it
+	// expires, the message is dropped from the connection, and a
+	// NodeError with a TimeoutError is reported.
+	MessageTimeout time.Duration
+
+	// identifies the node as the origin of the messages it sends, and
+	// as the destination of the messages sent with SendTo. when not set,
+	// a random id is generated.
+	ID string
+
+	// when set, the node receives only the messages matching its
+	// subscriptions, and no messages without subscriptions. this way, a
+	// node that only relays the messages between its parent and its
+	// children advertises only the interests of its children, and its
+	// subtree is not sent the messages that no node in it is interested
+	// in.
+	Relay bool
+
+	// the number of message ids remembered to drop the duplicates in
+	// circular topologies, defaults to DefaultSeenCapacity
+	SeenCapacity int
+
+	// the time while a message id is remembered, defaults to
+	// DefaultSeenWindow
+	SeenWindow time.Duration
+
+	// the policy applied when a connection has more pending messages
+	// than the MessageBuffer. it can be overridden for a connection with
+	// WithOverflow.
+	Overflow OverflowPolicy
+
+	// the number of errors buffered until they are received from the
+	// error channel, defaults to DefaultErrorBuffer. when the buffer is
+	// full, the errors are dropped and counted in the stats.
+	ErrorBuffer int
+
+	// the number of events buffered until they are received from the
+	// event channel, defaults to DefaultEventBuffer. when the buffer is
+	// full, the events are dropped and counted in the stats.
+	EventBuffer int
+
+	// the period of the heartbeats sent to the parent and to the
+	// children that are known to be nodes. zero disables the
+	// heartbeats. the connected nodes need to use the same setting.
+	HeartbeatInterval time.Duration
+
+	// the time after a connection known to be a node is closed, when
+	// nothing was received from it since it became known to be a node,
+	// or since the last message. defaults to three times the
+	// HeartbeatInterval.
+	HeartbeatTimeout time.Duration
+
+	// when set, the node sends a session token to its parent after
+	// joining it, so that a parent with SessionGrace can send it the
+	// messages missed while it was disconnected. the token is sent when
+	// the parent is known to be a node.
+	ResumeSessions bool
+
+	// the time while the session of a disconnected child is kept. when
+	// the child reconnects during this time, the messages sent to it
+	// since shortly before the disconnection are sent again, and it drops
+	// the ones already received. the messages sent to the child after
+	// it reconnected, but before its session was resumed, may arrive
+	// before the replayed ones. it should not be longer than the
+	// SeenWindow of the children. zero disables the sessions.
+	SessionGrace time.Duration
+
+	// the number of messages kept for a session, defaults to
+	// DefaultSessionBuffer. when a child misses more messages, it
+	// receives only the latest ones.
+	SessionBuffer int
 }
 
 func removeOutgoing(ms []*outgoingMessage, m *outgoingMessage) []*outgoingMessage {
@@ -63,26 +233,45 @@ func removeOutgoing(ms []*outgoingMessage, m *outgoingMessage) []*outgoingMessag
 	return ms
 }
 
-func removeNodeConn(cs []nodeConn, c nodeConn) []nodeConn {
-	for i, ci := range cs {
-		if ci == c {
-			cs, cs[len(cs)-1] = append(cs[:i], cs[i+1:]...), nil
-			break
-		}
-	}
+// the children of a node, indexed for constant time lookup and removal.
+// the order of the children changes on removal.
+type connSet struct {
+	conns []nodeConn
+	index map[nodeConn]int
+}
+
+func newConnSet() *connSet {
+	return &connSet{index: make(map[nodeConn]int)}
+}
+
+func (s *connSet) add(c nodeConn) {
+	s.index[c] = len(s.conns)
+	s.conns = append(s.conns, c)
+}
 
-	return cs
+func (s *connSet) has(c nodeConn) bool {
+	_, ok := s.index[c]
+	return ok
 }
 
-func discardOutgoing(om *outgoingMessage) {
-	close(om.discard)
-	for _, conn := range om.conns {
-		conn <- &connControl{typ: cancelOutgoing, message: om}
+func (s *connSet) remove(c nodeConn) {
+	i, ok := s.index[c]
+	if !ok {
+		return
 	}
+
+	last := len(s.conns) - 1
+	s.conns[i] = s.conns[last]
+	s.index[s.conns[i]] = i
+	s.conns[last] = nil
+	s.conns = s.conns[:last]
+	delete(s.index, c)
 }
 
 func runConnection(
 	c Connection,
+	local bool,
+	stats *nodeStats,
 	im chan<- *incomingMessage,
 	nctl chan<- *nodeControl,
 	control chan *connControl) {
@@ -93,28 +282,55 @@ func runConnection(
 		outm       Message
 		outbox     []*outgoingMessage
 		receiver   <-chan Message
-        closed bool
+		closed     bool
 		fwdReceive chan<- *incomingMessage
 		fwdSend    chan<- Message
 		nodeQueue  []*nodeControl
 		sendnc     chan<- *nodeControl
 		nc         *nodeControl
+		peer       bool
+		remote     <-chan struct{}
 	)
 
+	if !local {
+		remote = remoteNode(c)
+	}
+
+	// the remote end can become known to be a node later, but not the
+	// other way around. the messages are checked, because the remote end
+	// may have announced itself before sending them.
+	checkPeer := func() {
+		if remote == nil {
+			return
+		}
+
+		select {
+		case <-remote:
+			peer, remote = true, nil
+			nodeQueue = append(nodeQueue, &nodeControl{
+				typ:      nodeConnPeer,
+				nodeConn: control})
+		default:
+		}
+	}
+
 	for {
+		checkPeer()
+
 		// receive incoming from outside or forward it to the node.
 		// when there is an incoming message to be forwarded,
 		// block the receiver by setting it to nil.
-        if !closed && in == nil {
-            receiver = c.Receive()
-        } else {
-            receiver = nil
-        }
-        if in == nil {
-            fwdReceive = nil
-        } else {
-            fwdReceive = im
-        }
+		if !closed && in == nil {
+			receiver = c.Receive()
+		} else {
+			receiver = nil
+		}
+
+		if in == nil {
+			fwdReceive = nil
+		} else {
+			fwdReceive = im
+		}
 
 		// when there is something in the outbox, forward it out.
 		// when there is nothing to send, block the sender
@@ -122,8 +338,20 @@ func runConnection(
 		if out == nil && len(outbox) > 0 {
 			fwdSend = c.Send()
 			out = outbox[0]
+			outbox[0] = nil
 			outbox = outbox[1:]
-			outm = *out.message
+			if peer {
+				outm = out.wireMessage()
+			} else if !local && out.envelope.routed() {
+				stats.droppedNonNode.Add(1)
+				nodeQueue = append(nodeQueue, &nodeControl{
+					typ:      connOutgoingDone,
+					nodeConn: control,
+					message:  out})
+				out, fwdSend = nil, nil
+			} else {
+				outm = *out.message
+			}
 		} else if out == nil {
 			fwdSend = nil
 		}
@@ -144,11 +372,14 @@ func runConnection(
 		// to avoid blocking the main node process that
 		// may send control messages anytime.
 		select {
+		case <-remote:
 		case m, open := <-receiver:
 			if open {
-				in = &incomingMessage{control, &m}
+				checkPeer()
+				m, e := unwrap(m)
+				in = &incomingMessage{source: control, message: &m, envelope: e, peer: peer}
 			} else {
-                closed = true
+				closed = true
 				nodeQueue = append(nodeQueue, &nodeControl{
 					typ:      nodeConnClosed,
 					nodeConn: control})
@@ -174,7 +405,7 @@ func runConnection(
 					outbox = removeOutgoing(outbox, ctl.message)
 				}
 			case closeNodeConn:
-                close(c.Send())
+				close(c.Send())
 				return
 			}
 		}
@@ -182,108 +413,155 @@ func runConnection(
 }
 
 // process for communicating between the node and a single connection
-func newNodeConn(c Connection, im chan<- *incomingMessage, nctl chan<- *nodeControl) nodeConn {
+// the local connection is the node's own connection, that receives the
+// messages without the envelope
+func newNodeConn(
+	c Connection,
+	local bool,
+	stats *nodeStats,
+	im chan<- *incomingMessage,
+	nctl chan<- *nodeControl) nodeConn {
+
 	control := make(chan *connControl)
-	go runConnection(c, im, nctl, control)
+	go runConnection(c, local, stats, im, nctl, control)
 	return control
 }
 
-func targetConns(source nodeConn, conns []nodeConn) []nodeConn {
-	// don't send the message when not coming from an
-	// existing connection, or there is no connection
-	// to send it to. don't send the message to the
-	// source connection.
+func newOutgoingMessage(m *incomingMessage, timeout time.Duration) *outgoingMessage {
+	om := &outgoingMessage{message: m.message, envelope: m.envelope, wire: &wireMessage{}}
+	if timeout > 0 {
+		om.deadline = time.Now().Add(timeout)
+	}
 
-	var (
-		targetConns  []nodeConn
-		existingConn bool
-	)
+	return om
+}
 
-	for _, c := range conns {
-		if c != nil && c != source {
-			targetConns = append(targetConns, c)
-		}
+// queues the message in the target connection
+func queueMessage(c nodeConn, om *outgoingMessage, q *queues) {
+	c <- &connControl{typ: newOutgoing, message: om}
+	q.push(c, om)
+}
 
-		if c == source {
-			existingConn = true
+// queues the messages of a resumed session in the connection of the child
+// again, with a new deadline
+func replayMessages(c nodeConn, replay []*outgoingMessage, timeout time.Duration, q *queues) {
+	for _, om := range replay {
+		r := *om
+		if timeout > 0 {
+			r.deadline = time.Now().Add(timeout)
 		}
-	}
 
-	if !existingConn {
-		return nil
+		queueMessage(c, &r, q)
 	}
+}
 
-	return targetConns
+// sends a control message to a single connection
+func sendControl(c nodeConn, control string, m Message) {
+	om := &outgoingMessage{
+		message:  &m,
+		envelope: &envelope{control: control},
+		wire:     &wireMessage{},
+		control:  true}
+	c <- &connControl{typ: newOutgoing, message: om}
 }
 
-func waitTimeoutOrDiscard(om *outgoingMessage, timeout time.Duration, nctl chan<- *nodeControl) {
-	var (
-		timeoutc <-chan time.Time
-		tout     *nodeControl
-		sendctl  chan<- *nodeControl
-	)
+// sends the ids reachable through the node and the interests of the
+// subtree to the parent, when the parent is known to be a node, and they
+// changed since the last advertisement
+func advertise(r *routing, parent nodeConn) {
+	if parent == nil || !r.peers[parent] {
+		return
+	}
 
-	if timeout > 0 {
-		timeoutc = time.After(timeout)
-		tout = &nodeControl{typ: outgoingTimeout, message: om}
+	if ids, changed := r.advertisement(); changed {
+		sendControl(parent, controlRoutes, Message{Key: ids})
 	}
 
-	for {
-		select {
-		case <-timeoutc:
-			// block the nodeControl channel until timeout
-			// by leaving it nil
-			sendctl = nctl
-		case sendctl <- tout:
-			sendctl = nil
-		case <-om.discard:
-			return
-		}
+	if is, changed := r.interestsAdvertisement(); changed {
+		sendControl(parent, controlInterests, Message{Val: is})
 	}
 }
 
-func dispatchMessage(
+// applies the overflow policy of a full target connection, and tells
+// whether the new message can be sent to it
+func applyOverflow(
 	m *incomingMessage,
-	timeout time.Duration,
-	control chan<- *nodeControl,
-	conns []nodeConn) *outgoingMessage {
+	c nodeConn,
+	q *queues,
+	stats *nodeStats,
+	err chan<- error) bool {
 
-	conns = targetConns(m.source, conns)
-	if len(conns) == 0 {
-		return nil
+	dropped, accepted := m.message, false
+	if q.conns[c].policy != OverflowDropNewest {
+		om := q.evict(c, m.message.Key)
+		q.pull(c, om)
+		c <- &connControl{typ: cancelOutgoing, message: om}
+		dropped, accepted = om.message, true
 	}
 
-	om := &outgoingMessage{
-		message: m.message,
-		conns:   conns,
-		discard: make(chan struct{})}
+	stats.droppedOverflow.Add(1)
+	conn := q.conns[c].conn
+	reportError(err, &stats.droppedErrors, &NodeError{
+		Kind:       ErrorOverflow,
+		Connection: conn,
+		Key:        dropped.Key,
+		Err:        &OverflowError{Message: *dropped, Connection: conn}})
+	return accepted
+}
 
-	if timeout > 0 {
-		go waitTimeoutOrDiscard(om, timeout, control)
+// checks the envelope of the message received from the parent or a
+// child, or stamps it as originating from this node, and decrements the
+// hop limit.
+// control messages are not stamped. the connections that are not known to
+// be nodes cannot send control messages, and they cannot set the origin.
+// returns false when the message was already seen, or when it is an
+// ignored control message.
+func receiveMessage(m *incomingMessage, ownConn nodeConn, id string, seq *uint64, seen *seenSet, now time.Time) bool {
+	e := m.envelope
+	if e == nil {
+		e = &envelope{}
+		m.envelope = e
 	}
 
-	for _, ci := range conns {
-		ci <- &connControl{typ: newOutgoing, message: om}
+	switch {
+	case m.source == ownConn:
+		e.control = ""
+	case !m.peer && e.control != "":
+		return false
+	case !m.peer:
+		e.origin, e.seq = "", 0
+	case e.control != "":
+		return true
 	}
 
-	return om
+	if m.source == ownConn || e.origin == "" {
+		*seq++
+		e.origin, e.seq = id, *seq
+	} else if e.ttl > 0 {
+		e.ttl--
+		m.expired = e.ttl == 0
+	}
+
+	return !seen.check(messageID{e.origin, e.seq}, now)
 }
 
-func findConnMessages(c nodeConn, ms []*outgoingMessage) []*outgoingMessage {
-	var result []*outgoingMessage
-	for _, om := range ms {
-		for _, ci := range om.conns {
-			if ci == c {
-				result = append(result, om)
-				break
-			}
+// drops the messages whose deadline passed from the queues of the
+// connections, and reports them
+func expireMessages(q *queues, now time.Time, stats *nodeStats, err chan<- error) {
+	for _, v := range q.wheel.expire(now) {
+		c := v.(nodeConn)
+		for _, om := range q.expire(c, now) {
+			c <- &connControl{typ: cancelOutgoing, message: om}
+			reportError(err, &stats.droppedErrors, &NodeError{
+				Kind:       ErrorTimeout,
+				Connection: q.conns[c].conn,
+				Key:        om.message.Key,
+				Err:        &TimeoutError{*om.message}})
 		}
 	}
-
-	return result
 }
 
-func closeNode(ownConn, parent nodeConn, children []nodeConn, outbox []*outgoingMessage) {
+func closeNode(ownConn, parent nodeConn, children []nodeConn) {
 	cls := &connControl{typ: closeNodeConn}
 	ownConn <- cls
 
@@ -294,33 +572,161 @@ func closeNode(ownConn, parent nodeConn, children []nodeConn, outbox []*outgoing
 	for _, ci := range children {
 		ci <- cls
 	}
-
-	for _, m := range outbox {
-		close(m.discard)
-	}
 }
 
 func runNode(
-	buffer int,
-	timeout time.Duration,
+	ctx context.Context,
+	o NodeOpt,
+	seen *seenSet,
+	r *routing,
+	stats *nodeStats,
 	control chan *nodeControl,
 	incoming chan *incomingMessage,
 	ownConn nodeConn,
-	err chan error) {
+	err chan error,
+	events chan<- Event,
+	done chan<- struct{}) {
+
+	defer close(done)
 
 	var (
 		receiveIncoming <-chan *incomingMessage
-		outbox          []*outgoingMessage
 		parent          nodeConn
-		children        []nodeConn
+		children        = newConnSet()
 		listen          <-chan Connection
+		seq             uint64
 	)
 
+	// while shutting down, the incoming messages are discarded, and the
+	// node is closed when the pending messages were sent or the context
+	// of the shutdown is done
+	var (
+		draining     *nodeControl
+		drainTimeout <-chan struct{}
+	)
+
+	var (
+		ticker *time.Ticker
+		tick   <-chan time.Time
+	)
+
+	var (
+		hb              = newHeartbeats(o.HeartbeatInterval, o.HeartbeatTimeout)
+		heartbeatTicker *time.Ticker
+		heartbeatTick   <-chan time.Time
+	)
+
+	if hb != nil {
+		heartbeatTicker = time.NewTicker(hb.interval)
+		heartbeatTick = heartbeatTicker.C
+	}
+
+	var (
+		sess          = newSessions(o.SessionGrace, o.SessionBuffer)
+		sessionTicker *time.Ticker
+		sessionTick   <-chan time.Time
+		token         string
+	)
+
+	if o.ResumeSessions {
+		token = newNodeID()
+	}
+
+	stop := func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+
+		if heartbeatTicker != nil {
+			heartbeatTicker.Stop()
+		}
+
+		if sessionTicker != nil {
+			sessionTicker.Stop()
+		}
+
+		closeNode(ownConn, parent, children.conns)
+	}
+
+	id := o.ID
+	q := newQueues(o.MessageBuffer, o.MessageTimeout)
+	q.add(ownConn, nil, o.Overflow)
+
+	// removes the parent or a child, after its connection was closed
+	disconnect := func(nc nodeConn) {
+		conn := q.conns[nc].conn
+		q.remove(nc)
+		hb.remove(nc)
+		nc <- &connControl{typ: closeNodeConn}
+		if nc == parent {
+			r.resetParent(parent)
+			parent = nil
+			e := newEvent(ParentChanged, nil)
+			e.Previous = conn
+			reportEvent(events, &stats.droppedEvents, e)
+			reportError(err, &stats.droppedErrors, &NodeError{
+				Kind:       ErrorDisconnected,
+				Connection: conn,
+				Err:        ErrDisconnected})
+		} else {
+			reportEvent(events, &stats.droppedEvents, newEvent(ChildDisconnected, conn))
+			sess.detach(nc, r.childInterests[nc], time.Now())
+			children.remove(nc)
+			r.remove(nc)
+			advertise(r, parent)
+		}
+	}
+
+	// marks a connection as a node. the heartbeats of the connection are
+	// tracked from this point, and the routes, the interests and the
+	// session are sent to the parent, once it is known to be a node.
+	setPeer := func(nc nodeConn) {
+		if !r.setPeer(nc) {
+			return
+		}
+
+		hb.seen(nc, time.Now())
+		if nc != parent {
+			return
+		}
+
+		advertise(r, parent)
+		if token != "" {
+			sendControl(parent, controlSession, Message{Val: token})
+		}
+	}
+
 	for {
-		// when the outbox is full, block all
+		if draining != nil && q.pending() == 0 {
+			stop()
+			draining.result <- shutdownResult{}
+			return
+		}
+
+		// run the ticker of the timeouts only while there are
+		// pending messages that can time out.
+		if q.wheel != nil && !q.wheel.empty() && ticker == nil {
+			ticker = time.NewTicker(q.wheel.resolution)
+			tick = ticker.C
+		} else if q.wheel != nil && q.wheel.empty() && ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+
+		// expire the sessions only while there are disconnected
+		// children
+		if sess != nil && sess.detached > 0 && sessionTicker == nil {
+			sessionTicker = time.NewTicker(timerResolution(sess.grace))
+			sessionTick = sessionTicker.C
+		} else if sess != nil && sess.detached == 0 && sessionTicker != nil {
+			sessionTicker.Stop()
+			sessionTicker, sessionTick = nil, nil
+		}
+
+		// when a blocking connection is full, block all
 		// incoming messages by setting the
 		// incoming channel to nil.
-		if len(outbox) > buffer {
+		if q.blocked > 0 {
 			receiveIncoming = nil
 		} else {
 			receiveIncoming = incoming
@@ -328,87 +734,382 @@ func runNode(
 
 		select {
 		case m := <-receiveIncoming:
-			om := dispatchMessage(m, timeout, control,
-				append([]nodeConn{ownConn, parent}, children...))
-			if om != nil {
-				outbox = append(outbox, om)
+			// don't accept messages from connections that were
+			// already closed
+			if m.source != ownConn && m.source != parent && !children.has(m.source) {
+				continue
+			}
+
+			// while shutting down, the messages are discarded, but
+			// they still show that the connection is alive
+			now := time.Now()
+			if m.peer {
+				hb.seen(m.source, now)
+			}
+
+			if draining != nil {
+				continue
+			}
+
+			if !receiveMessage(m, ownConn, id, &seq, seen, now) {
+				continue
 			}
+
+			if m.peer {
+				setPeer(m.source)
+			}
+
+			if m.envelope.control == controlHeartbeat {
+				continue
+			}
+
+			if m.envelope.control != "" {
+				if m.source != parent {
+					switch m.envelope.control {
+					case controlSession:
+						if sess == nil {
+							break
+						}
+
+						replay, previous := sess.attach(m.source, m.message.Val)
+						if previous != nil && children.has(previous) {
+							disconnect(previous)
+						}
+
+						replayMessages(m.source, replay, o.MessageTimeout, q)
+					case controlRoutes:
+						r.update(m.source, m.message.Key)
+					case controlInterests:
+						r.updateInterests(m.source, decodeInterests(m.message.Val))
+					}
+
+					advertise(r, parent)
+				}
+
+				continue
+			}
+
+			var (
+				conns   [2]nodeConn
+				subtree []nodeConn
+			)
+
+			switch {
+			case m.envelope.to != "":
+				next := r.next(m.envelope.to, ownConn, parent)
+				if m.envelope.to != id && (next == nil || next == m.source) {
+					stats.droppedUnreachable.Add(1)
+				}
+
+				conns[0] = next
+			case m.envelope.scope == ScopeUp:
+				// messages sent upward are accepted only from
+				// the children
+				if m.source == parent {
+					continue
+				}
+
+				conns = [2]nodeConn{ownConn, parent}
+			case m.envelope.scope == ScopeDown:
+				// messages sent downward are accepted only from
+				// the parent
+				if m.source != parent && m.source != ownConn {
+					continue
+				}
+
+				conns[0], subtree = ownConn, children.conns
+			default:
+				conns, subtree = [2]nodeConn{ownConn, parent}, children.conns
+			}
+
+			// the target connections are selected in a single pass,
+			// because there can be a large number of children
+			var (
+				om         *outgoingMessage
+				droppedTTL bool
+			)
+
+			for _, cs := range [2][]nodeConn{conns[:], subtree} {
+				for _, c := range cs {
+					switch {
+					case c == nil || c == m.source:
+						continue
+					case m.envelope.to == "" && !r.interested(c, m.message.Key, ownConn, parent):
+						continue
+					case m.expired && c != ownConn:
+						droppedTTL = true
+						continue
+					case q.full(c) && !applyOverflow(m, c, q, stats, err):
+						continue
+					}
+
+					if om == nil {
+						om = newOutgoingMessage(m, o.MessageTimeout)
+					}
+
+					queueMessage(c, om, q)
+					sess.record(om, c)
+				}
+			}
+
+			if droppedTTL {
+				stats.droppedTTL.Add(1)
+			}
+
+			downward := m.envelope.to == "" && m.envelope.scope != ScopeUp && !m.expired
+			if downward && sess != nil && sess.detached > 0 {
+				if om == nil {
+					om = newOutgoingMessage(m, o.MessageTimeout)
+				}
+
+				sess.recordDetached(om)
+			}
+		case now := <-tick:
+			expireMessages(q, now, stats, err)
+		case now := <-sessionTick:
+			sess.expire(now)
+		case now := <-heartbeatTick:
+			for _, c := range hb.expired(now) {
+				reportError(err, &stats.droppedErrors, &NodeError{
+					Kind:       ErrorHeartbeatTimeout,
+					Connection: q.conns[c].conn,
+					Err:        ErrHeartbeatTimeout})
+				disconnect(c)
+			}
+
+			for c := range r.peers {
+				sendControl(c, controlHeartbeat, Message{})
+			}
+		case <-ctx.Done():
+			abandoned := q.pending()
+			stop()
+			if draining != nil {
+				draining.result <- shutdownResult{abandoned: abandoned, err: ctx.Err()}
+			}
+
+			return
+		case <-drainTimeout:
+			abandoned := q.pending()
+			stop()
+			draining.result <- shutdownResult{abandoned: abandoned, err: draining.ctx.Err()}
+			return
 		case c := <-control:
 			switch c.typ {
-			case outgoingTimeout:
-				discardOutgoing(c.message)
-				outbox = removeOutgoing(outbox, c.message)
-				go func() { err <- &TimeoutError{*c.message.message} }()
 			case connOutgoingDone:
-				c.message.conns = removeNodeConn(c.message.conns, c.nodeConn)
-				if len(c.message.conns) == 0 {
-					discardOutgoing(c.message)
-					outbox = removeOutgoing(outbox, c.message)
-				}
+				q.pull(c.nodeConn, c.message)
 			case nodeConnClosed:
-				// closing the node's own connection means that the node is closed
+				// closing the node's own connection means that the node
+				// is closed, unless it is shutting down, when the
+				// pending messages are still delivered to it
 				if c.nodeConn == ownConn {
-					closeNode(ownConn, parent, children, outbox)
-					return
-				}
-
-				oms := findConnMessages(c.nodeConn, outbox)
-				for _, om := range oms {
-					om.conns = removeNodeConn(om.conns, c.nodeConn)
-					if len(om.conns) == 0 {
-						discardOutgoing(om)
-						outbox = removeOutgoing(outbox, om)
+					if draining != nil {
+						continue
 					}
+
+					stop()
+					return
 				}
 
-				c.nodeConn <- &connControl{typ: closeNodeConn}
-				if c.nodeConn == parent {
-					parent = nil
-					go func() { err <- ErrDisconnected }()
-				} else {
-					children = removeNodeConn(children, c.nodeConn)
+				disconnect(c.nodeConn)
+			case nodeConnPeer:
+				if c.nodeConn == parent || children.has(c.nodeConn) {
+					setPeer(c.nodeConn)
 				}
 			case joinParent:
+				e := newEvent(ParentChanged, c.conn)
 				if parent != nil {
+					e.Previous = q.conns[parent].conn
+					r.resetParent(parent)
+					q.remove(parent)
+					hb.remove(parent)
 					parent <- &connControl{typ: closeNodeConn}
 				}
 
-				parent = newNodeConn(c.conn, incoming, control)
+				parent = newNodeConn(c.conn, false, stats, incoming, control)
+				q.add(parent, c.conn, connectionPolicy(c.conn, o.Overflow))
+
+				// when the parent is already known to be a node,
+				// the session is sent before the first message
+				select {
+				case <-remoteNode(c.conn):
+					setPeer(parent)
+				default:
+				}
+
+				reportEvent(events, &stats.droppedEvents, e)
 			case listenChildren:
 				if listen != nil {
 					panic("already listening")
 				}
 
 				listen = c.listener.Connections()
+			case subscribe:
+				r.subscribe(c.key)
+				advertise(r, parent)
+			case unsubscribe:
+				r.unsubscribe(c.key)
+				advertise(r, parent)
+			case shutdown:
+				if draining != nil {
+					c.result <- shutdownResult{err: ErrClosed}
+					continue
+				}
+
+				draining, drainTimeout = c, c.ctx.Done()
+				listen = nil
 			}
 		case c, open := <-listen:
 			if !open {
 				listen = nil
-				for _, c := range children {
+				for _, c := range children.conns {
+					reportEvent(events, &stats.droppedEvents, newEvent(ChildDisconnected, q.conns[c].conn))
+					sess.detach(c, r.childInterests[c], time.Now())
+					q.remove(c)
+					hb.remove(c)
 					c <- &connControl{typ: closeNodeConn}
+					r.remove(c)
 				}
 
-				children = nil
-				go func() { err <- ErrListenerDisconnected }()
+				children = newConnSet()
+				advertise(r, parent)
+
+				reportError(err, &stats.droppedErrors, &NodeError{
+					Kind: ErrorListenerDisconnected,
+					Err:  ErrListenerDisconnected})
 			} else {
-				children = append(children, newNodeConn(c, incoming, control))
+				announceNode(c)
+				child := newNodeConn(c, false, stats, incoming, control)
+				children.add(child)
+				q.add(child, c, connectionPolicy(c, o.Overflow))
+				r.addChild(child)
+				advertise(r, parent)
+				reportEvent(events, &stats.droppedEvents, newEvent(ChildConnected, c))
 			}
 		}
 	}
 }
 
+func newNodeID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
 func NewNode(buffer int, timeout time.Duration) Node {
+	return NewNodeWithOptions(NodeOpt{MessageBuffer: buffer, MessageTimeout: timeout})
+}
+
+func NewNodeWithOptions(o NodeOpt) Node {
+	return NewNodeContext(context.Background(), o)
+}
+
+// creates a node that is closed when the context is done
+func NewNodeContext(ctx context.Context, o NodeOpt) Node {
+	return newNode(ctx, o)
+}
+
+func newNode(ctx context.Context, o NodeOpt) *node {
+	if o.ID == "" {
+		o.ID = newNodeID()
+	}
+
 	intern, extern := NewInProcConnection()
 	control := make(chan *nodeControl)
 	incoming := make(chan *incomingMessage)
-	ownConn := newNodeConn(intern, incoming, control)
-	err := make(chan error)
-	go runNode(buffer, timeout, control, incoming, ownConn, err)
-	return &node{extern: extern, control: control, err: err}
+	stats := &nodeStats{}
+	ownConn := newNodeConn(intern, true, stats, incoming, control)
+	if o.ErrorBuffer <= 0 {
+		o.ErrorBuffer = DefaultErrorBuffer
+	}
+
+	if o.EventBuffer <= 0 {
+		o.EventBuffer = DefaultEventBuffer
+	}
+
+	err := make(chan error, o.ErrorBuffer)
+	seen := newSeenSet(o.SeenCapacity, o.SeenWindow)
+	r := newRouting(o.ID, o.Relay)
+	events := make(chan Event, o.EventBuffer)
+	done := make(chan struct{})
+	go runNode(ctx, o, seen, r, stats, control, incoming, ownConn, err, events, done)
+	return &node{
+		id:      o.ID,
+		extern:  extern,
+		control: control,
+		err:     err,
+		events:  events,
+		stats:   stats,
+		done:    done}
 }
 
 func (n *node) Send() chan<- Message    { return n.extern.Send() }
 func (n *node) Receive() <-chan Message { return n.extern.Receive() }
-func (n *node) Join(c Connection)       { n.control <- &nodeControl{typ: joinParent, conn: c} }
-func (n *node) Listen(l Listener)       { n.control <- &nodeControl{typ: listenChildren, listener: l} }
+func (n *node) Listen(l Listener)       { n.sendControl(&nodeControl{typ: listenChildren, listener: l}) }
 func (n *node) Error() <-chan error     { return n.err }
+func (n *node) Events() <-chan Event    { return n.events }
+func (n *node) ID() string              { return n.id }
+
+// the node announces itself on the connection before returning, see
+// nodeTransport. when the node is already closed, the connection is
+// closed.
+func (n *node) Join(c Connection) {
+	announceNode(c)
+	if !n.sendControl(&nodeControl{typ: joinParent, conn: c}) {
+		discardConnection(c)
+	}
+}
+
+// the node receives only the messages whose key starts with one of the
+// subscribed prefixes. a node without subscriptions receives every
+// message, unless it is a relay, see NodeOpt.
+func (n *node) Subscribe(prefix []string) {
+	n.sendControl(&nodeControl{typ: subscribe, key: prefix})
+}
+
+// removes a prefix added with Subscribe
+func (n *node) Unsubscribe(prefix []string) {
+	n.sendControl(&nodeControl{typ: unsubscribe, key: prefix})
+}
+
+// control messages sent to a closed node are ignored
+func (n *node) sendControl(c *nodeControl) bool {
+	select {
+	case n.control <- c:
+		return true
+	case <-n.done:
+		return false
+	}
+}
+
+// stops accepting messages, and closes the node when the pending messages
+// were sent to every connection, or when the context is done. returns the
+// number of the pending messages abandoned due to the context, and the
+// error of the context in this case. messages received by the node during
+// the shutdown are discarded. when the node is already closed or shutting
+// down, returns ErrClosed.
+func (n *node) Shutdown(ctx context.Context) (int, error) {
+	result := make(chan shutdownResult, 1)
+	if !n.sendControl(&nodeControl{typ: shutdown, ctx: ctx, result: result}) {
+		return 0, ErrClosed
+	}
+
+	r := <-result
+	return r.abandoned, r.err
+}
+
+// sends a message only to the node with the given id, along the path in
+// the tree
+func (n *node) SendTo(id string, m Message) { n.Send() <- withDestination(m, id) }
+
+func (n *node) Stats() NodeStats {
+	return NodeStats{
+		DroppedTTL:         n.stats.droppedTTL.Load(),
+		DroppedUnreachable: n.stats.droppedUnreachable.Load(),
+		DroppedOverflow:    n.stats.droppedOverflow.Load(),
+		DroppedNonNode:     n.stats.droppedNonNode.Load(),
+		DroppedErrors:      n.stats.droppedErrors.Load(),
+		DroppedEvents:      n.stats.droppedEvents.Load()}
+}
@@ -129,8 +129,8 @@ func testMessageBlock(n Node, buffer int, timeout time.Duration, from, block Con
 	go func() {
 		terr = callTimeout(func() {
 			for {
-				err := <-n.Error()
-				if _, ok := err.(*TimeoutError); ok {
+				var terr *TimeoutError
+				if errors.As(<-n.Error(), &terr) {
 					return
 				}
 			}
@@ -230,8 +230,11 @@ func createTree(n int) []Node {
 		children := createTree(nn)
 
 		if len(children) > 0 {
-			c, p := NewInProcConnection()
-			listener <- p
+			c, err := listener.Connect()
+			if err != nil {
+				panic(err)
+			}
+
 			children[0].Join(c)
 		}
 
@@ -540,7 +543,7 @@ func TestCloseParent(t *testing.T) {
 	testTimeout(t, func() {
 		for {
 			err := <-n.Error()
-			if err == ErrDisconnected {
+			if errors.Is(err, ErrDisconnected) {
 				return
 			}
 		}
@@ -558,6 +561,51 @@ func TestCloseChild(t *testing.T) {
 	})
 }
 
+func TestCloseManyChildren(t *testing.T) {
+	n, _, _, children := createTestNode(0, 0, false, 600)
+	for i := 0; i < len(children); i += 2 {
+		close(children[i].Send())
+	}
+
+	testTimeout(t, func() {
+		for i := 0; i < len(children); i += 2 {
+			if _, open := <-children[i].Receive(); open {
+				t.Error("failed to close child")
+			}
+		}
+	})
+
+	testTimeout(t, func() {
+		n.Send() <- Message{}
+		for i := 1; i < len(children); i += 2 {
+			<-children[i].Receive()
+		}
+	})
+}
+
+func TestConnSet(t *testing.T) {
+	s := newConnSet()
+	cs := make([]nodeConn, 5)
+	for i := range cs {
+		cs[i] = make(chan *connControl)
+		s.add(cs[i])
+	}
+
+	s.remove(cs[1])
+	s.remove(cs[4])
+	s.remove(cs[1])
+
+	if len(s.conns) != 3 || s.has(cs[1]) || s.has(cs[4]) {
+		t.Error("failed to remove connections")
+	}
+
+	for _, c := range []nodeConn{cs[0], cs[2], cs[3]} {
+		if !s.has(c) || s.conns[s.index[c]] != c {
+			t.Error("invalid index")
+		}
+	}
+}
+
 func TestChangeParent(t *testing.T) {
 	n, parent, _, _ := createTestNode(0, 0, true, 0)
 	nextpl, nextpr := NewInProcConnection()
@@ -580,7 +628,7 @@ func TestChangeListener(t *testing.T) {
 	close(l.(InProcListener))
 	testTimeout(t, func() {
 		err := <-n.Error()
-		if err != ErrListenerDisconnected {
+		if !errors.Is(err, ErrListenerDisconnected) {
 			t.Error("failed to disconnect listener")
 		}
 
@@ -1,6 +1,9 @@
 package cast
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func benchmarkDispatch(b *testing.B, parent bool, childCount int) {
 	if childCount > 500 && testing.Short() {
@@ -107,3 +110,29 @@ func BenchmarkTree100(b *testing.B)  { benchmarkTree(b, 100) }
 func BenchmarkTree200(b *testing.B)  { benchmarkTree(b, 200) }
 func BenchmarkTree500(b *testing.B)  { benchmarkTree(b, 500) }
 func BenchmarkTree1000(b *testing.B) { benchmarkTree(b, 1000) }
+
+func benchmarkDispatchTimeout(b *testing.B, childCount int) {
+	if childCount > 500 && testing.Short() {
+		b.Skip()
+	}
+
+	n, p, _, children := createTestNode(0, time.Second, true, childCount)
+	go receiveAll(p)
+	for _, c := range children {
+		go receiveAll(c)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.Send() <- Message{}
+	}
+
+	b.StopTimer()
+	close(n.Send())
+}
+
+func BenchmarkTimeoutChildren1(b *testing.B)     { benchmarkDispatchTimeout(b, 1) }
+func BenchmarkTimeoutChildren10(b *testing.B)    { benchmarkDispatchTimeout(b, 10) }
+func BenchmarkTimeoutChildren100(b *testing.B)   { benchmarkDispatchTimeout(b, 100) }
+func BenchmarkTimeoutChildren1000(b *testing.B)  { benchmarkDispatchTimeout(b, 1000) }
+func BenchmarkTimeoutChildren10000(b *testing.B) { benchmarkDispatchTimeout(b, 10000) }
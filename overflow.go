@@ -0,0 +1,61 @@
+package cast
+
+import (
+	"fmt"
+
+	"github.com/aryszka/keyval"
+)
+
+// defines what happens when a connection has more pending outgoing
+// messages than the message buffer of the node
+type OverflowPolicy int
+
+const (
+	// the node stops receiving incoming messages until the connection
+	// catches up
+	OverflowBlock OverflowPolicy = iota
+
+	// the new message is not sent to the connection
+	OverflowDropNewest
+
+	// the oldest pending message of the connection is dropped
+	OverflowDropOldest
+
+	// the pending message of the connection with the same key as the
+	// new message is dropped, or, when there is no such message, the
+	// oldest one
+	OverflowCoalesce
+)
+
+// error sent when a message was dropped by the overflow policy of a
+// connection. the connection is nil when the message was dropped from
+// the node's own connection.
+type OverflowError struct {
+	Message    Message
+	Connection Connection
+}
+
+type overflowConnection struct {
+	Connection
+	policy OverflowPolicy
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf(
+		"message dropped due to overflow, message: %s",
+		keyval.JoinKey(e.Message.Key))
+}
+
+// sets the overflow policy of a connection passed to Join or received
+// from a listener, overriding the policy of the node
+func WithOverflow(c Connection, p OverflowPolicy) Connection {
+	return overflowConnection{Connection: c, policy: p}
+}
+
+func connectionPolicy(c Connection, p OverflowPolicy) OverflowPolicy {
+	if oc, ok := c.(overflowConnection); ok {
+		return oc.policy
+	}
+
+	return p
+}
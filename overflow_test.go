@@ -0,0 +1,189 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func createOverflowNode(p OverflowPolicy) (Node, Connection, Connection, Connection) {
+	n := NewNodeWithOptions(NodeOpt{MessageBuffer: 1})
+	go receiveAll(n)
+
+	parent, parentRemote := NewInProcConnection()
+	n.Join(parentRemote)
+
+	l := make(InProcListener)
+	n.Listen(l)
+	child, childRemote := NewInProcConnection()
+	wrapped := WithOverflow(childRemote, p)
+	l <- wrapped
+
+	return n, parent, child, wrapped
+}
+
+func testOverflow(t *testing.T, p OverflowPolicy, send []Message, expect []Message, dropped []Message) {
+	n, parent, child, wrapped := createOverflowNode(p)
+
+	testTimeout(t, func() {
+		for _, m := range send {
+			parent.Send() <- m
+		}
+	})
+
+	// the dropped messages are reported after the node processed them
+	testTimeout(t, func() {
+		vals := make(map[string]bool)
+		for range dropped {
+			err := <-n.Error()
+			var oe *OverflowError
+			if !errors.As(err, &oe) {
+				t.Error("invalid error", err)
+				continue
+			}
+
+			if oe.Connection != wrapped {
+				t.Error("invalid connection")
+			}
+
+			vals[oe.Message.Val] = true
+		}
+
+		for _, m := range dropped {
+			if !vals[m.Val] {
+				t.Error("failed to report dropped message", m)
+			}
+		}
+	})
+
+	testTimeout(t, func() {
+		for _, m := range expect {
+			r, _ := unwrap(<-child.Receive())
+			if !messagesEqual(r, m) {
+				t.Error("invalid message", r, m)
+			}
+		}
+	})
+
+	expectNoMessage(t, child)
+
+	if s := n.(Monitor).Stats(); s.DroppedOverflow != uint64(len(dropped)) {
+		t.Error("invalid count of dropped messages", s.DroppedOverflow)
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	ms := []Message{{Val: "1"}, {Val: "2"}, {Val: "3"}, {Val: "4"}}
+	testOverflow(t, OverflowDropNewest, ms, ms[:2], ms[2:])
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	ms := []Message{{Val: "1"}, {Val: "2"}, {Val: "3"}, {Val: "4"}}
+	testOverflow(t, OverflowDropOldest, ms, ms[2:], ms[:2])
+}
+
+func TestOverflowCoalesce(t *testing.T) {
+	ms := []Message{
+		{Key: []string{"foo"}, Val: "1"},
+		{Key: []string{"bar"}, Val: "2"},
+		{Key: []string{"foo"}, Val: "3"},
+		{Key: []string{"baz"}, Val: "4"},
+	}
+
+	testOverflow(t, OverflowCoalesce, ms, ms[2:], ms[:2])
+}
+
+func TestOverflowNodePolicy(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{Overflow: OverflowDropNewest})
+	parent, parentRemote := NewInProcConnection()
+	n.Join(parentRemote)
+
+	testTimeout(t, func() {
+		for i := 0; i < 3; i++ {
+			parent.Send() <- Message{}
+		}
+	})
+
+	// the own connection is read only after the errors, so that the
+	// node processed all the messages
+	testTimeout(t, func() {
+		for i := 0; i < 2; i++ {
+			var oe *OverflowError
+			if !errors.As(<-n.Error(), &oe) {
+				t.Error("invalid error")
+			}
+		}
+
+		<-n.Receive()
+	})
+}
+
+func TestSlowConnectionDoesNotBlockOthers(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{MessageBuffer: 1})
+	go receiveAll(n)
+
+	l := make(InProcListener)
+	n.Listen(l)
+
+	slow, slowRemote := NewInProcConnection()
+	l <- WithOverflow(slowRemote, OverflowDropOldest)
+	fast, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for range n.Error() {
+		}
+	}()
+
+	testTimeout(t, func() {
+		for i := 0; i < 12; i++ {
+			n.Send() <- Message{}
+			<-fast.Receive()
+		}
+	})
+
+	testTimeout(t, func() {
+		<-slow.Receive()
+		<-slow.Receive()
+	})
+
+	expectNoMessage(t, slow)
+}
+
+func TestTimeoutPerConnection(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{MessageBuffer: 1, MessageTimeout: 30 * time.Millisecond})
+	l := make(InProcListener)
+	n.Listen(l)
+
+	slow, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fast, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		<-fast.Receive()
+	})
+
+	testTimeout(t, func() {
+		var terr *TimeoutError
+		if !errors.As(<-n.Error(), &terr) || terr.Message.Val != "foo" {
+			t.Error("invalid error", terr)
+		}
+	})
+
+	select {
+	case err := <-n.Error():
+		t.Error("unexpected error", err)
+	case <-time.After(60 * time.Millisecond):
+	}
+
+	expectNoMessage(t, slow)
+}
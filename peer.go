@@ -0,0 +1,64 @@
+package cast
+
+// the control message that a stream connection sends to tell the remote
+// end that the local end is a node. it is consumed by the stream
+// connection, and it is never received from the connection.
+const controlNode = "node"
+
+// implemented by the connections whose transport tells the two ends
+// whether the other end is a node
+//
+// a node announces itself on the connection of its parent and on the
+// connections of its children, and it sends the messages with the
+// envelope, and the control messages, only to the connections whose
+// remote end is known to be a node. the other connections receive the
+// messages with the original key, and the control messages received from
+// them are ignored.
+//
+// the connections received from a listener are expected to be accepted
+// by a node, so the connections returned by the Connect method of the
+// built-in interfaces know from the start that their remote end is a
+// node. the stream connections created with NewStreamConnection don't
+// announce the node, because the remote end may not understand the
+// announcement, while the ones created with NewNodeStreamConnection do.
+//
+// the messages whose delivery depends on the envelope, because they were
+// sent with SendTo, WithScope or WithTTL, are not sent to the connections
+// that are not known to be nodes. they are dropped and counted in the
+// stats.
+type nodeTransport interface {
+	announceNode()
+
+	// closed when the remote end is known to be a node
+	remoteNode() <-chan struct{}
+}
+
+func transportOf(c Connection) (nodeTransport, bool) {
+	if oc, ok := c.(overflowConnection); ok {
+		c = oc.Connection
+	}
+
+	t, ok := c.(nodeTransport)
+	return t, ok
+}
+
+func announceNode(c Connection) {
+	if t, ok := transportOf(c); ok {
+		t.announceNode()
+	}
+}
+
+// returns nil when the transport cannot tell whether the remote end is a
+// node
+func remoteNode(c Connection) <-chan struct{} {
+	if t, ok := transportOf(c); ok {
+		return t.remoteNode()
+	}
+
+	return nil
+}
+
+func isNodeAnnouncement(m Message) bool {
+	m, e := unwrap(m)
+	return e != nil && e.control == controlNode && len(m.Key) == 0
+}
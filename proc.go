@@ -3,6 +3,7 @@ package cast
 import (
 	"fmt"
 	"github.com/aryszka/keyval"
+	"sync"
 	"time"
 )
 
@@ -14,8 +15,10 @@ type MessageChannel chan Message
 type InProcListener chan Connection
 
 type inProcConnection struct {
-	local  chan Message
-	remote *inProcConnection
+	local    chan Message
+	remote   *inProcConnection
+	node     chan struct{}
+	nodeOnce sync.Once
 }
 
 // error send in case of a timeout on a connection that handles it
@@ -31,7 +34,7 @@ type bufferedConnection struct {
 
 type timeoutMessage struct {
 	message Message
-	timeout chan struct{}
+	timer   *wheelTimer
 }
 
 type timeoutConnection struct {
@@ -53,8 +56,10 @@ func (l InProcListener) Connections() <-chan Connection { return l }
 
 // doesn't return an error.
 // blocks until listener connections are received
+// the remote end is a listener, so it is known to be a node
 func (l InProcListener) Connect() (Connection, error) {
-	local, remote := NewInProcConnection()
+	local, remote := newInProcConnection()
+	remote.announceNode()
 	l <- remote
 	return local, nil
 }
@@ -63,15 +68,24 @@ func (l InProcListener) Connect() (Connection, error) {
 // representing an in-process communication channel
 // error channel always blocking
 func NewInProcConnection() (Connection, Connection) {
-	local := &inProcConnection{local: make(chan Message)}
-	remote := &inProcConnection{local: make(chan Message)}
+	return newInProcConnection()
+}
+
+func newInProcConnection() (*inProcConnection, *inProcConnection) {
+	local := &inProcConnection{local: make(chan Message), node: make(chan struct{})}
+	remote := &inProcConnection{local: make(chan Message), node: make(chan struct{})}
 	local.remote = remote
 	remote.remote = local
 	return local, remote
 }
 
-func (c *inProcConnection) Send() chan<- Message    { return c.local }
-func (c *inProcConnection) Receive() <-chan Message { return c.remote.local }
+func (c *inProcConnection) Send() chan<- Message        { return c.local }
+func (c *inProcConnection) Receive() <-chan Message     { return c.remote.local }
+func (c *inProcConnection) remoteNode() <-chan struct{} { return c.remote.node }
+
+func (c *inProcConnection) announceNode() {
+	c.nodeOnce.Do(func() { close(c.node) })
+}
 
 func (e *TimeoutError) Error() string {
 	return fmt.Sprintf(
@@ -132,42 +146,71 @@ func NewTimeoutConnection(c Connection, t time.Duration) Connection {
 			forward chan<- Message
 			ctm     *timeoutMessage
 			cm      Message
-			cto     <-chan struct{}
 			tms     []*timeoutMessage
+			ticker  *time.Ticker
+			tick    <-chan time.Time
 		)
 
+		// the timeouts of the messages are stored in a timer wheel
+		// driven by a single ticker, that runs only while there are
+		// pending messages
+		wheel := newTimerWheel(timerResolution(t))
+
 		for {
 			if ctm == nil && len(tms) > 0 {
 				forward = c.Send()
 				ctm = tms[0]
 				tms = tms[1:]
 				cm = ctm.message
-				cto = ctm.timeout
 			} else if ctm == nil {
 				forward = nil
-				cto = nil
+			}
+
+			if !wheel.empty() && ticker == nil {
+				ticker = time.NewTicker(wheel.resolution)
+				tick = ticker.C
+			} else if wheel.empty() && ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
 			}
 
 			select {
 			case m, open := <-send:
 				if open {
-					to := make(chan struct{})
-					time.AfterFunc(t, func() {
-						close(to)
-					})
-					tms = append(tms, &timeoutMessage{m, to})
+					now := time.Now()
+					tm := &timeoutMessage{message: m}
+					tm.timer = wheel.add(now, now.Add(t), tm)
+					tms = append(tms, tm)
 				} else {
 					send = nil
 					if ctm == nil {
+						if ticker != nil {
+							ticker.Stop()
+						}
+
 						close(c.Send())
 						return
 					}
 				}
 			case forward <- cm:
+				wheel.cancel(ctm.timer)
 				ctm = nil
-			case <-cto:
-				ec <- &TimeoutError{ctm.message}
-				ctm = nil
+			case now := <-tick:
+				for _, v := range wheel.expire(now) {
+					tm := v.(*timeoutMessage)
+					ec <- &TimeoutError{tm.message}
+					if tm == ctm {
+						ctm = nil
+						continue
+					}
+
+					for i, tmi := range tms {
+						if tmi == tm {
+							tms = append(tms[:i], tms[i+1:]...)
+							break
+						}
+					}
+				}
 				// case err, open := <-c.Error():
 				// 	if open {
 				// 		ec <- err
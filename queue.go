@@ -0,0 +1,176 @@
+package cast
+
+import "time"
+
+// the outgoing messages pending on a connection, in the order they were
+// dispatched. control messages are not tracked. the timer is set to the
+// deadline of the oldest pending message.
+type connQueue struct {
+	conn    Connection
+	policy  OverflowPolicy
+	pending []*outgoingMessage
+	timer   *wheelTimer
+}
+
+// the queues of the connections of a node. the node is blocked while
+// there is a connection with OverflowBlock and more pending messages
+// than the buffer.
+//
+// the pending messages of a connection are dispatched with the same
+// timeout, in the order of their deadlines, so only the oldest one of
+// each connection is stored in the timer wheel.
+type queues struct {
+	buffer  int
+	conns   map[nodeConn]*connQueue
+	blocked int
+	wheel   *timerWheel
+}
+
+// the wheel is nil when the messages don't time out
+func newQueues(buffer int, timeout time.Duration) *queues {
+	q := &queues{buffer: buffer, conns: make(map[nodeConn]*connQueue)}
+	if timeout > 0 {
+		q.wheel = newTimerWheel(timerResolution(timeout))
+	}
+
+	return q
+}
+
+// sets the timer of the connection to the deadline of its oldest
+// pending message
+func (q *queues) arm(c nodeConn, cq *connQueue) {
+	if q.wheel == nil {
+		return
+	}
+
+	var deadline time.Time
+	if len(cq.pending) > 0 {
+		deadline = cq.pending[0].deadline
+	}
+
+	if cq.timer != nil && cq.timer.deadline.Equal(deadline) && !cq.timer.done {
+		return
+	}
+
+	if cq.timer != nil {
+		q.wheel.cancel(cq.timer)
+		cq.timer = nil
+	}
+
+	if !deadline.IsZero() {
+		cq.timer = q.wheel.add(time.Now(), deadline, c)
+	}
+}
+
+func (q *queues) over(cq *connQueue) bool {
+	return cq.policy == OverflowBlock && len(cq.pending) > q.buffer
+}
+
+func (q *queues) add(c nodeConn, conn Connection, p OverflowPolicy) {
+	q.conns[c] = &connQueue{conn: conn, policy: p}
+}
+
+func (q *queues) remove(c nodeConn) {
+	cq, ok := q.conns[c]
+	if !ok {
+		return
+	}
+
+	if q.over(cq) {
+		q.blocked--
+	}
+
+	if cq.timer != nil && q.wheel != nil {
+		q.wheel.cancel(cq.timer)
+	}
+
+	delete(q.conns, c)
+}
+
+func (q *queues) push(c nodeConn, om *outgoingMessage) {
+	cq := q.conns[c]
+	before := q.over(cq)
+	cq.pending = append(cq.pending, om)
+	if !before && q.over(cq) {
+		q.blocked++
+	}
+
+	if len(cq.pending) == 1 {
+		q.arm(c, cq)
+	}
+}
+
+func (q *queues) pull(c nodeConn, om *outgoingMessage) {
+	cq, ok := q.conns[c]
+	if !ok {
+		return
+	}
+
+	// the messages are usually done in the order they were queued
+	before := q.over(cq)
+	head := len(cq.pending) > 0 && cq.pending[0] == om
+	if head {
+		cq.pending[0] = nil
+		cq.pending = cq.pending[1:]
+	} else {
+		cq.pending = removeOutgoing(cq.pending, om)
+	}
+
+	if before && !q.over(cq) {
+		q.blocked--
+	}
+
+	if head {
+		q.arm(c, cq)
+	}
+}
+
+// removes and returns the pending messages of a connection whose
+// deadline passed
+func (q *queues) expire(c nodeConn, now time.Time) []*outgoingMessage {
+	cq, ok := q.conns[c]
+	if !ok {
+		return nil
+	}
+
+	var expired []*outgoingMessage
+	for len(cq.pending) > 0 && !now.Before(cq.pending[0].deadline) {
+		om := cq.pending[0]
+		q.pull(c, om)
+		expired = append(expired, om)
+	}
+
+	q.arm(c, cq)
+	return expired
+}
+
+// returns true when a new message cannot be added to the connection
+// without applying its overflow policy
+func (q *queues) full(c nodeConn) bool {
+	return len(q.conns[c].pending) > q.buffer
+}
+
+// the number of messages pending on all the connections
+func (q *queues) pending() int {
+	var n int
+	for _, cq := range q.conns {
+		n += len(cq.pending)
+	}
+
+	return n
+}
+
+// returns the pending message to be dropped from the connection for a new
+// message with the given key
+func (q *queues) evict(c nodeConn, key []string) *outgoingMessage {
+	cq := q.conns[c]
+	if cq.policy == OverflowCoalesce {
+		for _, om := range cq.pending {
+			if len(om.message.Key) == len(key) && hasPrefix(om.message.Key, key) {
+				return om
+			}
+		}
+	}
+
+	return cq.pending[0]
+}
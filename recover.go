@@ -1,76 +1,542 @@
 package cast
 
 import (
+    "context"
     "time"
     "errors"
+    "math/rand"
+    "sync/atomic"
+)
+
+const (
+    // the default delay before retrying to connect, after every
+    // candidate parent failed
+    DefaultInitialBackoff = 100 * time.Millisecond
+
+    // the default limit of the delay between the retries
+    DefaultMaxBackoff = 10 * time.Second
 )
 
 type recoveryNode struct {
-    node Node
+    node *node
     timeout time.Duration
+    initialBackoff time.Duration
+    maxBackoff time.Duration
+    jitter float64
+    strategy ParentStrategy
+    failBackInterval time.Duration
+    probeTimeout time.Duration
+    original bool
     parents []Interface
     err chan error
+    droppedErrors atomic.Uint64
+    events chan Event
+    droppedEvents atomic.Uint64
     incoming Connection
+    discovery ParentSource
+    store *store
+    shutdown chan *nodeControl
+    done chan struct{}
+}
+
+// provides the list of the candidate parents, every time it changes
+// e.g. *Discovery
+type ParentSource interface {
+    Parents() <-chan []Interface
 }
 
 type RecoveryOpt struct {
     MessageBuffer int
     MessageTimeout time.Duration
+
+    // the id of the node, see NodeOpt
+    ID string
+
+    // only the messages matching the subscriptions are received, see
+    // NodeOpt
+    Relay bool
+
+    // the number of errors buffered until they are received, see
+    // NodeOpt
+    ErrorBuffer int
+
+    // the number of events buffered until they are received, see
+    // NodeOpt
+    EventBuffer int
+
+    // the heartbeats that detect a broken parent connection, see
+    // NodeOpt
+    HeartbeatInterval time.Duration
+    HeartbeatTimeout time.Duration
+
+    // the sessions that let the node receive the messages missed while
+    // it was reconnecting, and its children, too, see NodeOpt
+    ResumeSessions bool
+    SessionGrace time.Duration
+    SessionBuffer int
+
+    // the time after a disconnection, while the node keeps trying to
+    // connect to the candidate parents. when the next backoff would pass
+    // it, the last round is made when it passes. when the last round
+    // fails, an ErrorRecoveryFailed error is reported, and the node stops
+    // trying until the list of the candidates changes. zero means trying
+    // forever.
     RecoveryTimeout time.Duration
+
     Parents []Interface
+
+    // when set, the list of the candidate parents is replaced by the
+    // updates received from it
+    Discovery ParentSource
+
+    // the delay before retrying, after every candidate parent failed. it
+    // is doubled after every failed round, up to MaxBackoff. defaults to
+    // DefaultInitialBackoff.
+    InitialBackoff time.Duration
+
+    // the limit of the delay, defaults to DefaultMaxBackoff
+    MaxBackoff time.Duration
+
+    // the fraction of the delay that is randomized, between 0 and 1, so
+    // that the nodes disconnected at the same time don't retry at the
+    // same time. zero means no jitter.
+    Jitter float64
+
+    // when any of the store limits is set, the messages sent while the
+    // node has no parent are stored, and sent in order once it joined a
+    // new parent. when the count or the size limit is exceeded, the
+    // oldest messages are dropped. the dropped and the expired messages
+    // are reported as errors. the size of a message is the length of its
+    // key, value and comment.
+    StoreCount int
+    StoreBytes int
+    StoreAge time.Duration
+
+    // the order of trying the candidate parents, defaults to
+    // StrategyOrdered. the selected parents are reported as
+    // ParentSelected and FailBack events.
+    Strategy ParentStrategy
+
+    // the period of checking whether the original parent is available
+    // again, with StrategyFailBack. defaults to DefaultFailBackInterval.
+    FailBackInterval time.Duration
+
+    // the time while the candidate parents are probed, with
+    // StrategyLeastLatency. the candidates that don't connect in time
+    // are reported as failed with ErrProbeTimeout. defaults to
+    // DefaultProbeTimeout.
+    ProbeTimeout time.Duration
 }
 
 var ErrRecoveryFailed = errors.New("recovery to all connections failed")
 
 func NewRecoveryNode(o RecoveryOpt) Node {
+    if o.ErrorBuffer <= 0 {
+        o.ErrorBuffer = DefaultErrorBuffer
+    }
+
+    if o.EventBuffer <= 0 {
+        o.EventBuffer = DefaultEventBuffer
+    }
+
+    if o.InitialBackoff <= 0 {
+        o.InitialBackoff = DefaultInitialBackoff
+    }
+
+    if o.MaxBackoff <= 0 {
+        o.MaxBackoff = DefaultMaxBackoff
+    }
+
+    if o.FailBackInterval <= 0 {
+        o.FailBackInterval = DefaultFailBackInterval
+    }
+
+    if o.ProbeTimeout <= 0 {
+        o.ProbeTimeout = DefaultProbeTimeout
+    }
+
     n := &recoveryNode{
-        node: NewNode(o.MessageBuffer, o.MessageTimeout),
+        node: newNode(context.Background(), NodeOpt{
+            MessageBuffer: o.MessageBuffer,
+            MessageTimeout: o.MessageTimeout,
+            ID: o.ID,
+            Relay: o.Relay,
+            ErrorBuffer: o.ErrorBuffer,
+            EventBuffer: o.EventBuffer,
+            HeartbeatInterval: o.HeartbeatInterval,
+            HeartbeatTimeout: o.HeartbeatTimeout,
+            ResumeSessions: o.ResumeSessions,
+            SessionGrace: o.SessionGrace,
+            SessionBuffer: o.SessionBuffer}),
         timeout: o.RecoveryTimeout,
+        initialBackoff: o.InitialBackoff,
+        maxBackoff: o.MaxBackoff,
+        jitter: o.Jitter,
+        strategy: o.Strategy,
+        failBackInterval: o.FailBackInterval,
+        probeTimeout: o.ProbeTimeout,
         parents: o.Parents,
-        err: make(chan error),
-        incoming: make(MessageChannel)}
+        err: make(chan error, o.ErrorBuffer),
+        events: make(chan Event, o.EventBuffer),
+        incoming: make(MessageChannel),
+        discovery: o.Discovery,
+        store: newStore(o.StoreCount, o.StoreBytes, o.StoreAge),
+        shutdown: make(chan *nodeControl),
+        done: make(chan struct{})}
     go n.runRecovery()
     return n
 }
 
-func (n *recoveryNode) connect() bool {
-    for i, p := range n.parents {
-        if c, err := p.Connect(); err == nil {
-            n.node.Join(c)
+// returns the delay after the given number of failed rounds
+func backoff(failed int, initial, max time.Duration, jitter float64) time.Duration {
+    d := initial
+    for i := 1; i < failed && d < max; i++ {
+        d *= 2
+    }
+
+    if d > max {
+        d = max
+    }
+
+    if jitter > 0 {
+        d -= time.Duration(jitter * rand.Float64() * float64(d))
+    }
+
+    return d
+}
+
+// closes a connection that is not used, and discards the messages
+// received on it until the remote end closes it, too
+func discardConnection(c Connection) {
+    close(c.Send())
+    go func() {
+        for range c.Receive() {}
+    }()
+}
+
+// tries the candidate parents in the order defined by the strategy, and
+// returns the failed attempts and the selected parent as events. with
+// StrategyOrdered, the parent connected to is moved to the end of the
+// list.
+func (n *recoveryNode) connect() (bool, []Event) {
+    if n.strategy == StrategyLeastLatency {
+        return n.connectLeastLatency()
+    }
+
+    var events []Event
+    for _, i := range candidateOrder(n.strategy, len(n.parents)) {
+        p := n.parents[i]
+        c, err := p.Connect()
+        if err != nil {
+            events = append(events, Event{Type: ReconnectFailed, Interface: p, Err: err})
+            continue
+        }
+
+        n.node.Join(c)
+        n.original = i == 0
+        if n.strategy == StrategyOrdered {
             n.parents = append(n.parents[i + 1:], n.parents[:i + 1]...)
-            return true
         }
+
+        return true, append(events, Event{Type: ParentSelected, Interface: p, Strategy: n.strategy})
+    }
+
+    return false, events
+}
+
+// the result of connecting to a candidate parent
+type probe struct {
+    index int
+    conn Connection
+    latency time.Duration
+    err error
+}
+
+// closes the connections of the probes that finish after the selection
+func discardProbes(probes <-chan probe, count int) {
+    for i := 0; i < count; i++ {
+        if p := <-probes; p.err == nil {
+            discardConnection(p.conn)
+        }
+    }
+}
+
+// probes the candidate parents in parallel by connecting to them, and
+// keeps the connection established first. the connections established
+// later, or after the probe timeout, are closed.
+func (n *recoveryNode) connectLeastLatency() (bool, []Event) {
+    probes := make(chan probe, len(n.parents))
+    for i, p := range n.parents {
+        go func(i int, p Interface) {
+            start := time.Now()
+            c, err := p.Connect()
+            probes <- probe{index: i, conn: c, latency: time.Since(start), err: err}
+        }(i, p)
+    }
+
+    timeout := time.NewTimer(n.probeTimeout)
+    defer timeout.Stop()
+
+    var events []Event
+    finished := make([]bool, len(n.parents))
+    for remaining := len(n.parents); remaining > 0; remaining-- {
+        select {
+        case pr := <-probes:
+            finished[pr.index] = true
+            p := n.parents[pr.index]
+            if pr.err != nil {
+                events = append(events, Event{Type: ReconnectFailed, Interface: p, Err: pr.err})
+                continue
+            }
+
+            go discardProbes(probes, remaining - 1)
+            n.node.Join(pr.conn)
+            return true, append(events, Event{
+                Type: ParentSelected,
+                Interface: p,
+                Strategy: n.strategy,
+                Latency: pr.latency})
+        case <-timeout.C:
+            for i, p := range n.parents {
+                if !finished[i] {
+                    events = append(events, Event{Type: ReconnectFailed, Interface: p, Err: ErrProbeTimeout})
+                }
+            }
+
+            go discardProbes(probes, remaining)
+            return false, events
+        }
+    }
+
+    return false, events
+}
+
+// connects to the original parent again, when it is available, replacing
+// the current parent
+func (n *recoveryNode) failBack() []Event {
+    if len(n.parents) == 0 {
+        return nil
+    }
+
+    p := n.parents[0]
+    c, err := p.Connect()
+    if err != nil {
+        return []Event{{Type: ReconnectFailed, Interface: p, Err: err, Backoff: n.failBackInterval}}
+    }
+
+    n.node.Join(c)
+    n.original = true
+    return []Event{{Type: FailBack, Interface: p, Strategy: n.strategy}}
+}
+
+// reports the messages dropped from the store
+func (n *recoveryNode) dropStored(ms []Message, expired bool) {
+    for _, m := range ms {
+        ne := &NodeError{Kind: ErrorOverflow, Key: m.Key, Err: &OverflowError{Message: m}}
+        if expired {
+            ne.Kind, ne.Err = ErrorTimeout, &TimeoutError{m}
+        }
+
+        reportError(n.err, &n.droppedErrors, ne)
     }
+}
 
-    println("connection failed")
-    go func() { n.err <- ErrRecoveryFailed }()
-    return false
+// reports the events of the connection attempts
+func (n *recoveryNode) reportEvents(events []Event) {
+    for _, e := range events {
+        reportEvent(n.events, &n.droppedEvents, e)
+    }
 }
 
+// after a disconnection, the candidate parents are tried in rounds. after
+// a failed round, the next one is started after the backoff, until the
+// recovery timeout passes. the backoff before the last round is shortened
+// to end when the recovery timeout passes. with StrategyFailBack, while
+// the node is connected to another parent than the original one, the
+// original one is tried periodically.
+//
+// while the node has no parent, the messages sent by the application are
+// stored, and while there are stored messages, the new ones are stored
+// after them, to keep the order.
+//
+// on shutdown, the node stops recovering, and while it has a parent, it
+// sends the stored messages before shutting down the inner node. the
+// messages left in the store are abandoned.
 func (n *recoveryNode) runRecovery() {
-    connected := false
+    defer close(n.done)
+
+    var (
+        hasParent bool
+        storeTicker *time.Ticker
+        storeTick <-chan time.Time
+    )
+
+    var (
+        draining *nodeControl
+        drainTimeout <-chan struct{}
+        abandonStore bool
+        abandoned int
+        nodeShutdown chan shutdownResult
+        inputClosed bool
+    )
+
+    var (
+        connected bool
+        failed int
+        retry <-chan time.Time
+        deadline time.Time
+        gaveUp bool
+        failBack <-chan time.Time
+    )
+
     incoming := newRelay(n.node, n.incoming)
 
+    var discovery <-chan []Interface
+    if n.discovery != nil {
+        discovery = n.discovery.Parents()
+    }
+
+    reset := func() {
+        failed, retry, gaveUp = 0, nil, false
+        deadline = time.Now().Add(n.timeout)
+    }
+
+    reset()
     for {
-        if !connected && len(n.parents) > 0 {
-            connected = n.connect()
+        if !connected && !gaveUp && retry == nil && draining == nil && len(n.parents) > 0 {
+            var events []Event
+            connected, events = n.connect()
+            if connected {
+                failed = 0
+            } else {
+                failed++
+                delay := backoff(failed, n.initialBackoff, n.maxBackoff, n.jitter)
+                if n.timeout > 0 && !time.Now().Before(deadline) {
+                    gaveUp = true
+                    ne := &NodeError{Kind: ErrorRecoveryFailed, Err: ErrRecoveryFailed}
+                    reportError(n.err, &n.droppedErrors, ne)
+                } else {
+                    if n.timeout > 0 && time.Now().Add(delay).After(deadline) {
+                        delay = time.Until(deadline)
+                    }
+
+                    events[len(events) - 1].Backoff = delay
+                    retry = time.After(delay)
+                }
+            }
+
+            n.reportEvents(events)
+        }
+
+        if connected && n.strategy == StrategyFailBack && !n.original && failBack == nil && draining == nil {
+            failBack = time.After(n.failBackInterval)
+        }
+
+        if draining != nil && nodeShutdown == nil && incoming.msg == nil &&
+            (n.store.empty() || !hasParent || abandonStore) {
+            abandoned = n.store.clear()
+            nodeShutdown = make(chan shutdownResult, 1)
+            go func(ctx context.Context) {
+                a, err := n.node.Shutdown(ctx)
+                nodeShutdown <- shutdownResult{abandoned: a, err: err}
+            }(draining.ctx)
+        }
+
+        if hasParent && !n.store.empty() && incoming.msg == nil {
+            incoming.received(n.store.pop(), true)
+        }
+
+        // check the age of the stored messages only while there are
+        // any
+        if n.store.maxAge > 0 && !n.store.empty() && storeTicker == nil {
+            storeTicker = time.NewTicker(timerResolution(n.store.maxAge))
+            storeTick = storeTicker.C
+        } else if n.store.empty() && storeTicker != nil {
+            storeTicker.Stop()
+            storeTicker, storeTick = nil, nil
+        }
+
+        receive := incoming.receive()
+        if inputClosed {
+            receive = nil
         }
 
         select {
+        case <-retry:
+            retry = nil
+        case <-failBack:
+            failBack = nil
+            n.reportEvents(n.failBack())
         case err := <-n.node.Error():
-            if err == ErrDisconnected && len(n.parents) > 0 {
-                println("disconnected")
-                connected = false
+            if errors.Is(err, ErrDisconnected) && (len(n.parents) > 0 || discovery != nil) {
+                connected, failBack = false, nil
+                reset()
             } else {
-                go func() { n.err <- err }()
+                reportError(n.err, &n.droppedErrors, err)
+            }
+        case e := <-n.node.Events():
+            if e.Type == ParentChanged {
+                hasParent = e.Connection != nil
+            }
+
+            reportEvent(n.events, &n.droppedEvents, e)
+        case now := <-storeTick:
+            n.dropStored(n.store.expire(now), true)
+        case m, open := <-receive:
+            // the messages received during the shutdown are discarded
+            if draining != nil {
+                inputClosed = !open
+                continue
+            }
+
+            recovering := len(n.parents) > 0 || n.discovery != nil
+            if open && n.store.enabled() && (!hasParent && recovering || !n.store.empty()) {
+                n.dropStored(n.store.push(m, time.Now()), false)
+                continue
             }
-        case m, open := <-incoming.receive():
+
             incoming.received(m, open)
             if !open {
+                if storeTicker != nil {
+                    storeTicker.Stop()
+                }
+
                 return
             }
         case incoming.send() <- incoming.message():
             incoming.sent()
+        case parents, open := <-discovery:
+            if open {
+                n.parents = parents
+                n.original = false
+                if gaveUp {
+                    reset()
+                }
+            } else {
+                discovery = nil
+            }
+        case c := <-n.shutdown:
+            if draining != nil {
+                c.result <- shutdownResult{err: ErrClosed}
+                continue
+            }
+
+            draining, drainTimeout = c, c.ctx.Done()
+            retry, failBack = nil, nil
+        case <-drainTimeout:
+            drainTimeout, abandonStore = nil, true
+        case r := <-nodeShutdown:
+            if storeTicker != nil {
+                storeTicker.Stop()
+            }
+
+            r.abandoned += abandoned
+            if r.err == nil && abandoned > 0 {
+                r.err = draining.ctx.Err()
+            }
+
+            draining.result <- r
+            return
         }
     }
 }
@@ -80,3 +546,30 @@ func (n *recoveryNode) Receive() <-chan Message { return n.node.Receive() }
 func (n *recoveryNode) Join(c Connection) { n.node.Join(c) }
 func (n *recoveryNode) Listen(l Listener) { n.node.Listen(l) }
 func (n *recoveryNode) Error() <-chan error { return n.err }
+func (n *recoveryNode) Events() <-chan Event { return n.events }
+func (n *recoveryNode) ID() string { return n.node.ID() }
+func (n *recoveryNode) SendTo(id string, m Message) { n.Send() <- withDestination(m, id) }
+func (n *recoveryNode) Subscribe(prefix []string) { n.node.Subscribe(prefix) }
+func (n *recoveryNode) Unsubscribe(prefix []string) { n.node.Unsubscribe(prefix) }
+
+// stops recovering, and shuts down the node the same way as a node
+// created with NewNode. the messages left in the store are counted as
+// abandoned.
+func (n *recoveryNode) Shutdown(ctx context.Context) (int, error) {
+    result := make(chan shutdownResult, 1)
+    select {
+    case n.shutdown <- &nodeControl{typ: shutdown, ctx: ctx, result: result}:
+    case <-n.done:
+        return 0, ErrClosed
+    }
+
+    r := <-result
+    return r.abandoned, r.err
+}
+
+func (n *recoveryNode) Stats() NodeStats {
+    s := n.node.Stats()
+    s.DroppedErrors += n.droppedErrors.Load()
+    s.DroppedEvents += n.droppedEvents.Load()
+    return s
+}
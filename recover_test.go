@@ -1,92 +1,11 @@
 package cast
 
 import (
+    "errors"
     "testing"
     "time"
 )
 
-type tiResponse struct {
-    conn Connection
-    err error
-}
-
-type testInterface struct {
-    connections chan Connection
-}
-
-var errTestInterface = errors.New("test interface disabled")
-
-func split(Connection c) (Connection, Connection) {
-}
-
-func join(left, right Connection) Connection {
-}
-
-func tap(left, right Connection) (Connection, Connection, Connection) {
-}
-
-func newTestInterface() *testInterface {
-    enabled := true
-    connections := make(chan Connection)
-    connect := make(chan chan tiResponse)
-
-    go func() {
-        var (
-            conn Connection
-            outbox []Connection
-            connsOut chan<- Connection
-        )
-
-        if len(outbox) > 0 && conn == nil {
-            conn = conns[0]
-            outbox = outbox[1:]
-            connsOut = connections
-        }
-
-        for {
-            select {
-            case e := <-enabledChan:
-                if !e && enabled {
-                    // how to disconnect existing connections?
-                    // need a test breakable connection
-                }
-            case connsOut <- conn:
-                conn = nil
-                connsOut = nil
-            case req := <-connect:
-                if enabled {
-                    req <- tiResponse{err: errTestInterface}
-                } else {
-                    local, remote := NewInProcConnection()
-                    outbox = append(outbox, local)
-                    req <- tiResponse{conn: remote}
-                }
-            }
-        }
-    }()
-
-    return &testInterface{connections}
-}
-
-func (i *testInterface) disable() {
-    i.enabled <- false
-}
-
-func (i *testInterface) enable() {
-    i.enabled <- true
-}
-
-func (i *testInterface) Connect() (Connection, error) {
-    req := make(chan tiResponse)
-    i.req <- req
-    rsp := <-req
-    return rsp.conn, rsp.err
-}
-
-func (i *testInterface) Connections() <-chan Connection {
-    return i.connections
-}
-
 func TestRecoverTakesOptions(t *testing.T) {
     c := make(MessageChannel)
     n := NewRecoveryNode(RecoveryOpt{MessageTimeout: time.Millisecond})
@@ -99,7 +18,8 @@ func TestRecoverTakesOptions(t *testing.T) {
 
     select {
     case err := <-n.Error():
-        if _, ok := err.(*TimeoutError); !ok {
+        var terr *TimeoutError
+        if !errors.As(err, &terr) {
             t.Error("failed to pass options, invalid error")
         }
     case <-time.After(120 * time.Millisecond):
@@ -200,7 +120,8 @@ func TestRecoverDoRecover(t *testing.T) {
     ln := make(InProcListener)
     tn.Listen(ln)
 
-    recoveryParent := func() (Node, Interface) {
+    // the interface of a closed parent fails, instead of blocking
+    recoveryParent := func() (Node, *failingInterface) {
         n := NewNode(0, 0)
         go receiveAll(n)
         c, err := ln.Connect()
@@ -211,14 +132,21 @@ func TestRecoverDoRecover(t *testing.T) {
         n.Join(c)
         l := make(InProcListener)
         n.Listen(l)
-        return n, l
+        return n, &failingInterface{iface: l}
     }
 
-    _, i1 := recoveryParent()
-    _, i2 := recoveryParent()
-    _, i3 := recoveryParent()
+    closeParent := func(p Node, i *failingInterface) {
+        i.fail.Store(1 << 30)
+        close(p.Send())
+    }
+
+    p1, i1 := recoveryParent()
+    p2, i2 := recoveryParent()
+    p3, i3 := recoveryParent()
 
-    rn := NewRecoveryNode(RecoveryOpt{Parents: []Interface{i1, i2, i3}})
+    rn := NewRecoveryNode(RecoveryOpt{
+        Parents: []Interface{i1, i2, i3},
+        RecoveryTimeout: 300 * time.Millisecond})
 
     testTimeout(t, func() {
         for {
@@ -230,7 +158,7 @@ func TestRecoverDoRecover(t *testing.T) {
         }
     })
 
-    close(p1.Send())
+    closeParent(p1, i1)
 
     time.Sleep(999 * time.Millisecond)
     testTimeout(t, func() {
@@ -243,7 +171,7 @@ func TestRecoverDoRecover(t *testing.T) {
         }
     })
 
-    close(p2.Send())
+    closeParent(p2, i2)
     time.Sleep(999 * time.Millisecond)
 
     testTimeout(t, func() {
@@ -256,12 +184,25 @@ func TestRecoverDoRecover(t *testing.T) {
         }
     })
 
-    close(p3.Send())
+    closeParent(p3, i3)
     time.Sleep(999 * time.Millisecond)
 
+    // the disconnections are reported before
     testTimeout(t, func() {
-        if err := <-rn.Error(); err != ErrRecoveryFailed {
-            t.Error(err)
+        for {
+            err := <-rn.Error()
+            var ne *NodeError
+            if errors.As(err, &ne) && ne.Kind == ErrorRecoveryFailed {
+                if ne.Err != ErrRecoveryFailed {
+                    t.Error("invalid error", ne.Err)
+                }
+
+                return
+            }
+
+            if !errors.Is(err, ErrDisconnected) {
+                t.Error(err)
+            }
         }
     })
 }
@@ -0,0 +1,371 @@
+package cast
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// sent by a node to its parent, listing the ids reachable through
+	// the node, including its own
+	controlRoutes = "routes"
+
+	// sent by a node to its parent, listing the key prefixes that the
+	// node and its subtree are interested in
+	controlInterests = "interests"
+)
+
+// key prefixes that a node or a subtree is interested in. nil means
+// interest in every message, and an empty, non-nil list means interest in
+// no message.
+type interests [][]string
+
+// the ids of the nodes reachable through the children of a node
+//
+// the nodes exchange control messages only with the connections that are
+// known to be nodes, see nodeTransport. this way, connections that are
+// not nodes never receive control messages. a child advertises its routes
+// and interests once its parent is known to be a node.
+//
+// the subtrees of the children that didn't advertise their interests, are
+// interested in every message. a relay node without subscriptions is not
+// interested in any message, so it advertises only the interests of its
+// children.
+//
+// the routes and the interests are updated incrementally, and they are
+// collected for the advertisement only when they changed.
+type routing struct {
+	id          string
+	relay       bool
+	children    map[nodeConn][]string
+	routes      map[string]nodeConn
+	peers       map[nodeConn]bool
+	advertised  []string
+	routesDirty bool
+
+	own                 interests
+	childInterests      map[nodeConn]interests
+	allChildren         int
+	advertisedInterests string
+	interestsAdvertised bool
+	interestsDirty      bool
+}
+
+func newRouting(id string, relay bool) *routing {
+	r := &routing{
+		id:             id,
+		relay:          relay,
+		children:       make(map[nodeConn][]string),
+		routes:         make(map[string]nodeConn),
+		peers:          make(map[nodeConn]bool),
+		childInterests: make(map[nodeConn]interests)}
+	if relay {
+		r.own = interests{}
+	}
+
+	return r
+}
+
+func hasPrefix(key, prefix []string) bool {
+	if len(prefix) > len(key) {
+		return false
+	}
+
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (is interests) match(key []string) bool {
+	if is == nil {
+		return true
+	}
+
+	for _, p := range is {
+		if hasPrefix(key, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the sorted prefixes, without the ones covered by a shorter
+// prefix
+func (is interests) normalize() interests {
+	if is == nil {
+		return nil
+	}
+
+	sorted := make(interests, len(is))
+	copy(sorted, is)
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) < len(sorted[j])
+		}
+
+		return strings.Join(sorted[i], "\x00") < strings.Join(sorted[j], "\x00")
+	})
+
+	n := interests{}
+	for _, p := range sorted {
+		if len(p) == 0 {
+			return nil
+		}
+
+		if !n.covers(p) {
+			n = append(n, p)
+		}
+	}
+
+	return n
+}
+
+func (is interests) covers(prefix []string) bool {
+	for _, p := range is {
+		if hasPrefix(prefix, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodes the prefixes as binary frames of messages with the prefix as
+// the key. interest in every message is encoded as a single empty
+// prefix, and interest in no message as no frames.
+func encodeInterests(is interests) string {
+	if is == nil {
+		is = interests{nil}
+	}
+
+	var b bytes.Buffer
+	enc := NewBinaryEncoder(&b)
+	for _, p := range is {
+		enc.Encode(Message{Key: p})
+	}
+
+	return b.String()
+}
+
+// invalid advertisements are handled as interest in every message
+func decodeInterests(s string) interests {
+	is := interests{}
+	dec := NewBinaryDecoder(strings.NewReader(s))
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil
+		}
+
+		is = append(is, m.Key)
+	}
+
+	return is.normalize()
+}
+
+// marks a connection as a node, returns true when it was not known yet
+func (r *routing) setPeer(c nodeConn) bool {
+	if r.peers[c] {
+		return false
+	}
+
+	r.peers[c] = true
+	return true
+}
+
+// removes the ids of a child from the routes
+func (r *routing) removeRoutes(child nodeConn) {
+	for _, id := range r.children[child] {
+		if r.routes[id] == child {
+			delete(r.routes, id)
+		}
+	}
+
+	delete(r.children, child)
+	r.routesDirty = true
+}
+
+// stores the ids advertised by a child
+func (r *routing) update(child nodeConn, ids []string) {
+	r.removeRoutes(child)
+	r.children[child] = ids
+	for _, id := range ids {
+		if id != r.id {
+			r.routes[id] = child
+		}
+	}
+}
+
+// adds a child, that is interested in every message until it advertises
+// its interests
+func (r *routing) addChild(c nodeConn) {
+	r.childInterests[c] = nil
+	r.allChildren++
+	r.interestsDirty = true
+}
+
+// removes a connection and the ids reachable through it
+func (r *routing) remove(c nodeConn) {
+	delete(r.peers, c)
+	if is, ok := r.childInterests[c]; ok {
+		if is == nil {
+			r.allChildren--
+		}
+
+		delete(r.childInterests, c)
+		r.interestsDirty = true
+	}
+
+	if _, ok := r.children[c]; ok {
+		r.removeRoutes(c)
+	}
+}
+
+// forgets the previous parent, the routes need to be advertised again to
+// the new one
+func (r *routing) resetParent(parent nodeConn) {
+	delete(r.peers, parent)
+	r.advertised = nil
+	r.interestsAdvertised = false
+}
+
+// adds a key prefix to the interests of the node
+func (r *routing) subscribe(prefix []string) {
+	r.own = append(r.own, prefix).normalize()
+	r.interestsDirty = true
+}
+
+// removes a key prefix from the interests of the node. when the last
+// prefix is removed, the node is interested in every message again, or,
+// when it is a relay, in no message.
+func (r *routing) unsubscribe(prefix []string) {
+	var own interests
+	if r.relay {
+		own = interests{}
+	}
+
+	for _, p := range r.own {
+		if len(p) != len(prefix) || !hasPrefix(p, prefix) {
+			own = append(own, p)
+		}
+	}
+
+	r.own = own
+	r.interestsDirty = true
+}
+
+// stores the interests advertised by a child
+func (r *routing) updateInterests(child nodeConn, is interests) {
+	if previous, ok := r.childInterests[child]; ok && previous == nil {
+		r.allChildren--
+	}
+
+	if is == nil {
+		r.allChildren++
+	}
+
+	r.childInterests[child] = is
+	r.interestsDirty = true
+}
+
+// returns the merged interests of the node and its children
+func (r *routing) subtreeInterests() interests {
+	if r.own == nil || r.allChildren > 0 {
+		return nil
+	}
+
+	merged := append(interests{}, r.own...)
+	for _, is := range r.childInterests {
+		merged = append(merged, is...)
+	}
+
+	return merged.normalize()
+}
+
+// returns the encoded interests of the subtree, when they changed since
+// the last advertisement
+func (r *routing) interestsAdvertisement() (string, bool) {
+	if r.interestsAdvertised && !r.interestsDirty {
+		return "", false
+	}
+
+	r.interestsDirty = false
+	is := encodeInterests(r.subtreeInterests())
+	if r.interestsAdvertised && is == r.advertisedInterests {
+		return "", false
+	}
+
+	r.advertisedInterests, r.interestsAdvertised = is, true
+	return is, true
+}
+
+// tells whether the message with the key needs to be sent to the own
+// connection or to a child. the parent is always interested.
+func (r *routing) interested(c nodeConn, key []string, ownConn, parent nodeConn) bool {
+	switch c {
+	case parent:
+		return true
+	case ownConn:
+		return r.own.match(key)
+	default:
+		return r.childInterests[c].match(key)
+	}
+}
+
+// returns the sorted ids reachable through the node, when they changed
+// since the last advertisement
+func (r *routing) advertisement() ([]string, bool) {
+	if r.advertised != nil && !r.routesDirty {
+		return nil, false
+	}
+
+	r.routesDirty = false
+	ids := []string{r.id}
+	for id := range r.routes {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	if len(ids) == len(r.advertised) {
+		changed := false
+		for i := range ids {
+			if ids[i] != r.advertised[i] {
+				changed = true
+				break
+			}
+		}
+
+		if !changed {
+			return nil, false
+		}
+	}
+
+	r.advertised = ids
+	return ids, true
+}
+
+// returns the connection that a message needs to be sent to, to reach a
+// node: the own connection, a child, or, when the route is not known,
+// the parent
+func (r *routing) next(to string, ownConn, parent nodeConn) nodeConn {
+	if to == r.id {
+		return ownConn
+	}
+
+	if c, ok := r.routes[to]; ok {
+		return c
+	}
+
+	return parent
+}
@@ -0,0 +1,142 @@
+package cast
+
+import (
+	"testing"
+	"time"
+)
+
+// waits until the routes and the interests advertised by the nodes reach
+// the root. the nodes advertise them before passing on any later message
+// toward the root, so when the root received a message from every node,
+// the advertisements were processed on the way.
+func waitAdvertised(t *testing.T, nodes []Node) {
+	testTimeout(t, func() {
+		for _, n := range nodes[1:] {
+			n.(Router).SendTo(nodes[0].(Router).ID(), Message{Key: []string{"advertised"}})
+		}
+
+		for range nodes[1:] {
+			<-nodes[0].Receive()
+		}
+	})
+}
+
+func createRoutedTree(t *testing.T, n int) []Node {
+	nodes := createTree(n)
+	waitAdvertised(t, nodes)
+	return nodes
+}
+
+func sendTo(t *testing.T, from, to Node, m Message) {
+	testTimeout(t, func() {
+		from.(Router).SendTo(to.(Router).ID(), m)
+		if r := <-to.Receive(); r.Val != m.Val || len(r.Key) != 0 {
+			t.Error("invalid message", r)
+		}
+	})
+}
+
+func waitDroppedUnreachable(t *testing.T, n Node, count uint64) {
+	testTimeout(t, func() {
+		for n.(Monitor).Stats().DroppedUnreachable != count {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func expectNoMessages(t *testing.T, nodes []Node, except ...Node) {
+	for _, n := range nodes {
+		var skip bool
+		for _, e := range except {
+			skip = skip || n == e
+		}
+
+		if !skip {
+			expectNoMessage(t, n)
+		}
+	}
+}
+
+func TestNodeID(t *testing.T) {
+	n := NewNodeWithOptions(NodeOpt{ID: "foo"})
+	if n.(Router).ID() != "foo" {
+		t.Error("invalid id", n.(Router).ID())
+	}
+
+	if NewNode(0, 0).(Router).ID() == NewNode(0, 0).(Router).ID() {
+		t.Error("failed to generate unique ids")
+	}
+}
+
+func TestSendToDownward(t *testing.T) {
+	nodes := createRoutedTree(t, 13)
+	sendTo(t, nodes[0], nodes[7], Message{Val: "foo"})
+	expectNoMessages(t, nodes, nodes[7])
+}
+
+func TestSendToAcrossBranches(t *testing.T) {
+	nodes := createRoutedTree(t, 13)
+	sendTo(t, nodes[2], nodes[10], Message{Val: "foo"})
+	expectNoMessages(t, nodes, nodes[10])
+}
+
+func TestSendToUnreachable(t *testing.T) {
+	nodes := createRoutedTree(t, 13)
+	sendTo(t, nodes[0], nodes[4], Message{})
+
+	nodes[4].(Router).SendTo("foo", Message{})
+	waitDroppedUnreachable(t, nodes[0], 1)
+	expectNoMessages(t, nodes)
+}
+
+func TestSendToRemovedChild(t *testing.T) {
+	nodes := createRoutedTree(t, 4)
+	sendTo(t, nodes[0], nodes[2], Message{})
+
+	close(nodes[2].Send())
+	testTimeout(t, func() {
+		for e := range nodes[0].(EventSource).Events() {
+			if e.Type == ChildDisconnected {
+				break
+			}
+		}
+	})
+
+	nodes[0].(Router).SendTo(nodes[2].(Router).ID(), Message{})
+	waitDroppedUnreachable(t, nodes[0], 1)
+	expectNoMessages(t, []Node{nodes[1], nodes[3]})
+}
+
+func TestNoControlMessagesToConnections(t *testing.T) {
+	n, parent, _, _ := createTestNode(0, 0, true, 0)
+	l := make(InProcListener)
+	n.Listen(l)
+
+	child := NewNode(0, 0)
+	c, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child.Join(c)
+
+	testTimeout(t, func() {
+		parent.Send() <- Message{Val: "foo"}
+		<-n.Receive()
+		<-child.Receive()
+	})
+
+	testTimeout(t, func() {
+		child.Send() <- Message{Val: "bar"}
+		<-n.Receive()
+		if m := <-parent.Receive(); m.Val != "bar" {
+			t.Error("unexpected message", m)
+		}
+	})
+
+	select {
+	case m := <-parent.Receive():
+		t.Error("unexpected message", m)
+	case <-time.After(12 * time.Millisecond):
+	}
+}
@@ -0,0 +1,77 @@
+package cast
+
+import "testing"
+
+func TestScopeUp(t *testing.T) {
+	nodes := createTree(13)
+	testTimeout(t, func() {
+		nodes[6].Send() <- WithScope(Message{Val: "foo"}, ScopeUp)
+		for _, n := range []Node{nodes[5], nodes[0]} {
+			if m := <-n.Receive(); m.Val != "foo" || len(m.Key) != 0 {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	expectNoMessages(t, nodes, nodes[0], nodes[5])
+}
+
+func TestScopeDown(t *testing.T) {
+	nodes := createTree(13)
+	testTimeout(t, func() {
+		nodes[5].Send() <- WithScope(Message{Val: "foo"}, ScopeDown)
+		for _, n := range nodes[6:9] {
+			if m := <-n.Receive(); m.Val != "foo" || len(m.Key) != 0 {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	expectNoMessages(t, nodes, nodes[6:9]...)
+}
+
+func TestScopeDownFromRoot(t *testing.T) {
+	nodes := createChain(6)
+	testTimeout(t, func() {
+		nodes[0].Send() <- WithScope(Message{}, ScopeDown)
+		for _, n := range nodes[1:] {
+			<-n.Receive()
+		}
+	})
+}
+
+func TestScopeBoth(t *testing.T) {
+	nodes := createTree(13)
+	testTimeout(t, func() {
+		nodes[6].Send() <- WithScope(Message{}, ScopeBoth)
+		for _, n := range nodes {
+			if n != nodes[6] {
+				<-n.Receive()
+			}
+		}
+	})
+}
+
+func TestScopeWrongDirection(t *testing.T) {
+	n, parent, _, children := createTestNode(0, 0, true, 1)
+	testTimeout(t, func() { children[0].Send() <- WithScope(Message{}, ScopeDown) })
+	expectNoMessage(t, n)
+	expectNoMessage(t, parent)
+
+	// the child sent a message with an envelope, and receives a control
+	// message, because it is considered a node
+	receiveAvailable(children[0])
+
+	testTimeout(t, func() { parent.Send() <- WithScope(Message{}, ScopeUp) })
+	expectNoMessage(t, n)
+	expectNoMessage(t, children[0])
+}
+
+func TestScopeEnvelope(t *testing.T) {
+	for _, s := range []Scope{ScopeBoth, ScopeUp, ScopeDown} {
+		_, e := unwrap(WithScope(Message{}, s))
+		if e == nil || e.scope != s {
+			t.Error("failed to set scope", s, e)
+		}
+	}
+}
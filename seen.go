@@ -0,0 +1,109 @@
+package cast
+
+import "time"
+
+const (
+	// default number of message ids remembered by a node
+	DefaultSeenCapacity = 1 << 16
+
+	// default time while a message id is remembered by a node
+	DefaultSeenWindow = time.Minute
+)
+
+type messageID struct {
+	origin string
+	seq    uint64
+}
+
+type seenEntry struct {
+	id   messageID
+	time time.Time
+}
+
+// bounded, time windowed set of message ids, to drop the messages
+// arriving again in circular topologies
+//
+// the entries are stored in the order of arrival in a ring buffer, that
+// grows until the capacity is reached
+type seenSet struct {
+	capacity int
+	window   time.Duration
+	ids      map[messageID]struct{}
+	order    []seenEntry
+	first    int
+	count    int
+}
+
+func newSeenSet(capacity int, window time.Duration) *seenSet {
+	if capacity <= 0 {
+		capacity = DefaultSeenCapacity
+	}
+
+	if window <= 0 {
+		window = DefaultSeenWindow
+	}
+
+	return &seenSet{
+		capacity: capacity,
+		window:   window,
+		ids:      make(map[messageID]struct{})}
+}
+
+func (s *seenSet) evictOldest() {
+	delete(s.ids, s.order[s.first].id)
+	s.order[s.first] = seenEntry{}
+	s.first++
+	if s.first == len(s.order) {
+		s.first = 0
+	}
+
+	s.count--
+}
+
+// doubles the ring buffer, limited by the capacity, and moves the entries
+// to its start
+func (s *seenSet) grow() {
+	size := 2 * len(s.order)
+	if size == 0 {
+		size = 16
+	}
+
+	if size > s.capacity {
+		size = s.capacity
+	}
+
+	order := make([]seenEntry, size)
+	n := copy(order, s.order[s.first:])
+	copy(order[n:], s.order[:s.first])
+	s.order, s.first = order, 0
+}
+
+// returns true when the id was already seen, otherwise stores it
+func (s *seenSet) check(id messageID, now time.Time) bool {
+	for s.count > 0 && now.Sub(s.order[s.first].time) >= s.window {
+		s.evictOldest()
+	}
+
+	// storing the id doesn't change the size of the set, when it was
+	// already seen
+	size := len(s.ids)
+	s.ids[id] = struct{}{}
+	if len(s.ids) == size {
+		return true
+	}
+
+	if s.count == s.capacity {
+		s.evictOldest()
+	} else if s.count == len(s.order) {
+		s.grow()
+	}
+
+	i := s.first + s.count
+	if i >= len(s.order) {
+		i -= len(s.order)
+	}
+
+	s.order[i] = seenEntry{id: id, time: now}
+	s.count++
+	return false
+}
@@ -0,0 +1,146 @@
+package cast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenSet(t *testing.T) {
+	s := newSeenSet(3, time.Minute)
+	now := time.Now()
+
+	if s.check(messageID{"foo", 1}, now) {
+		t.Error("unexpected seen")
+	}
+
+	if !s.check(messageID{"foo", 1}, now) {
+		t.Error("failed to detect duplicate")
+	}
+
+	if s.check(messageID{"bar", 1}, now) || s.check(messageID{"foo", 2}, now) {
+		t.Error("unexpected seen")
+	}
+
+	s.check(messageID{"foo", 3}, now)
+	if s.check(messageID{"foo", 1}, now) {
+		t.Error("failed to evict over capacity")
+	}
+
+	if !s.check(messageID{"foo", 3}, now.Add(59*time.Second)) {
+		t.Error("evicted too early")
+	}
+
+	if s.check(messageID{"foo", 3}, now.Add(time.Minute)) {
+		t.Error("failed to evict after window")
+	}
+}
+
+func TestSeenSetGrows(t *testing.T) {
+	s := newSeenSet(40, time.Minute)
+	now := time.Now()
+	for i := uint64(0); i < 100; i++ {
+		if s.check(messageID{"foo", i}, now) {
+			t.Error("unexpected seen", i)
+		}
+	}
+
+	for i := uint64(99); i >= 60; i-- {
+		if !s.check(messageID{"foo", i}, now) {
+			t.Error("failed to detect duplicate", i)
+		}
+	}
+
+	if s.check(messageID{"foo", 59}, now) {
+		t.Error("failed to evict over capacity")
+	}
+}
+
+func expectNoMessage(t *testing.T, c Connection) {
+	select {
+	case m := <-c.Receive():
+		t.Error("unexpected message", m)
+	case <-time.After(12 * time.Millisecond):
+	}
+}
+
+func createCycle(t *testing.T, count int) ([]Node, []InProcListener) {
+	var (
+		nodes     []Node
+		listeners []InProcListener
+	)
+
+	for i := 0; i < count; i++ {
+		n := NewNode(0, 0)
+		l := make(InProcListener)
+		n.Listen(l)
+		nodes = append(nodes, n)
+		listeners = append(listeners, l)
+	}
+
+	for i, n := range nodes {
+		c, err := listeners[(i+1)%count].Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n.Join(c)
+	}
+
+	return nodes, listeners
+}
+
+func TestCycleDropsDuplicates(t *testing.T) {
+	nodes, _ := createCycle(t, 3)
+
+	testTimeout(t, func() {
+		nodes[0].Send() <- Message{Val: "foo"}
+		for _, n := range nodes[1:] {
+			if m := <-n.Receive(); m.Val != "foo" {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	for _, n := range nodes {
+		expectNoMessage(t, n)
+	}
+}
+
+func TestCycleWithChildDropsDuplicates(t *testing.T) {
+	nodes, listeners := createCycle(t, 4)
+
+	c, err := listeners[2].Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(0, 0)
+	n.Join(c)
+	nodes = append(nodes, n)
+
+	for i := range nodes {
+		testTimeout(t, func() {
+			nodes[i].Send() <- Message{}
+			for j, n := range nodes {
+				if j != i {
+					<-n.Receive()
+				}
+			}
+		})
+
+		for _, n := range nodes {
+			expectNoMessage(t, n)
+		}
+	}
+}
+
+func TestRawConnectionMessagesStamped(t *testing.T) {
+	n, p, _, children := createTestNode(0, 0, true, 1)
+	testTimeout(t, func() {
+		for i := 0; i < 2; i++ {
+			p.Send() <- Message{Val: "foo"}
+			<-n.Receive()
+			<-children[0].Receive()
+		}
+	})
+}
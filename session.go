@@ -0,0 +1,134 @@
+package cast
+
+import "time"
+
+const (
+	// sent by a node to its parent after joining it, when session
+	// resumption is enabled, with the session token as the value
+	controlSession = "session"
+
+	// the number of messages kept by default for a session to be
+	// replayed
+	DefaultSessionBuffer = 256
+)
+
+// the messages recently sent to a child, and, while the child is
+// disconnected, the messages that it missed
+type session struct {
+	token     string
+	conn      nodeConn
+	interests interests
+	buffer    []*outgoingMessage
+	expires   time.Time
+}
+
+// the sessions of the children of a node, identified by the token sent by
+// the children. when a child reconnects during the grace period, the
+// messages in the buffer of its session are sent to it again, and it
+// drops the ones that it already received, as duplicates. a nil value
+// means that the sessions are disabled.
+type sessions struct {
+	grace    time.Duration
+	size     int
+	byToken  map[string]*session
+	byConn   map[nodeConn]*session
+	detached int
+}
+
+func newSessions(grace time.Duration, size int) *sessions {
+	if grace <= 0 {
+		return nil
+	}
+
+	if size <= 0 {
+		size = DefaultSessionBuffer
+	}
+
+	return &sessions{
+		grace:   grace,
+		size:    size,
+		byToken: make(map[string]*session),
+		byConn:  make(map[nodeConn]*session)}
+}
+
+func (s *session) record(om *outgoingMessage, size int) {
+	if len(s.buffer) == size {
+		s.buffer[0] = nil
+		s.buffer = s.buffer[1:]
+	}
+
+	s.buffer = append(s.buffer, om)
+}
+
+// connects a child to its session, and returns the messages to be
+// replayed, and the previous connection of the session, when it was still
+// connected
+func (ss *sessions) attach(c nodeConn, token string) ([]*outgoingMessage, nodeConn) {
+	s, ok := ss.byToken[token]
+	if !ok {
+		s = &session{token: token}
+		ss.byToken[token] = s
+	} else if s.conn == nil {
+		ss.detached--
+	}
+
+	previous := s.conn
+	if previous != nil {
+		delete(ss.byConn, previous)
+	}
+
+	s.conn = c
+	ss.byConn[c] = s
+	return s.buffer, previous
+}
+
+// keeps the session of a disconnected child during the grace period
+func (ss *sessions) detach(c nodeConn, is interests, now time.Time) {
+	if ss == nil {
+		return
+	}
+
+	s, ok := ss.byConn[c]
+	if !ok {
+		return
+	}
+
+	delete(ss.byConn, c)
+	s.conn, s.interests, s.expires = nil, is, now.Add(ss.grace)
+	ss.detached++
+}
+
+// stores a message sent to a child in its session
+func (ss *sessions) record(om *outgoingMessage, c nodeConn) {
+	if ss == nil {
+		return
+	}
+
+	if s, ok := ss.byConn[c]; ok {
+		s.record(om, ss.size)
+	}
+}
+
+// stores a message sent to the children in the sessions of the
+// disconnected children that are interested in it
+func (ss *sessions) recordDetached(om *outgoingMessage) {
+	if ss == nil || ss.detached == 0 {
+		return
+	}
+
+	for _, s := range ss.byToken {
+		if s.conn == nil && s.interests.match(om.message.Key) {
+			s.record(om, ss.size)
+		}
+	}
+}
+
+// removes the sessions whose grace period passed
+func (ss *sessions) expire(now time.Time) {
+	for token, s := range ss.byToken {
+		if s.conn == nil && !now.Before(s.expires) {
+			delete(ss.byToken, token)
+			ss.detached--
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package cast
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// connects to the listener through a relay, that drops the messages in
+// transit and closes both sides, when broken
+func breakableConnection(t *testing.T, l InProcListener) (Connection, func()) {
+	local, relayChild := newInProcConnection()
+	relayParent, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the relay passes the messages of two nodes
+	relayChild.announceNode()
+	announceNode(relayParent)
+
+	quit := make(chan struct{})
+	relay := func(from, to Connection, done func()) {
+		defer done()
+		for {
+			select {
+			case m, open := <-from.Receive():
+				if !open {
+					return
+				}
+
+				select {
+				case to.Send() <- m:
+				case <-quit:
+					return
+				}
+			case <-quit:
+				return
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go relay(relayChild, relayParent, wg.Done)
+	go relay(relayParent, relayChild, wg.Done)
+	go func() {
+		wg.Wait()
+		close(relayChild.Send())
+		close(relayParent.Send())
+	}()
+
+	return local, func() { close(quit) }
+}
+
+// connects the child, and makes sure that the parent received its session
+// when there is nothing to be replayed
+func joinSession(t *testing.T, parent, child Node, l InProcListener, wait bool) func() {
+	c, breakConn := breakableConnection(t, l)
+	child.Join(c)
+	if wait {
+		testTimeout(t, func() {
+			child.Send() <- Message{Val: "hello"}
+			<-parent.Receive()
+		})
+	}
+
+	return breakConn
+}
+
+func disconnectSession(t *testing.T, parent, child Node, breakConn func()) {
+	breakConn()
+	testTimeout(t, func() {
+		for e := range parent.(EventSource).Events() {
+			if e.Type == ChildDisconnected {
+				break
+			}
+		}
+
+		for e := range child.(EventSource).Events() {
+			if e.Type == ParentChanged && e.Connection == nil {
+				break
+			}
+		}
+	})
+}
+
+// returns the parent, the child with a session, the listener of the
+// parent, and the messages received by another child of the parent, that
+// shows when the parent processed a message
+func createSession(t *testing.T, grace time.Duration) (Node, Node, InProcListener, <-chan Message) {
+	parent := NewNodeWithOptions(NodeOpt{SessionGrace: grace})
+	l := make(InProcListener)
+	parent.Listen(l)
+	child := NewNodeWithOptions(NodeOpt{ResumeSessions: true})
+	go func() {
+		for range child.Error() {
+		}
+	}()
+
+	observer, err := l.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observed := make(chan Message, 64)
+	go func() {
+		for m := range observer.Receive() {
+			observed <- m
+		}
+	}()
+
+	return parent, child, l, observed
+}
+
+func waitObserved(t *testing.T, observed <-chan Message, val string) {
+	testTimeout(t, func() {
+		for m := range observed {
+			if m.Val == val {
+				return
+			}
+		}
+	})
+}
+
+func TestSessionResumption(t *testing.T) {
+	parent, child, l, observed := createSession(t, time.Second)
+	breakConn := joinSession(t, parent, child, l, true)
+	testTimeout(t, func() {
+		parent.Send() <- Message{Val: "foo"}
+		<-child.Receive()
+	})
+
+	disconnectSession(t, parent, child, breakConn)
+	testTimeout(t, func() {
+		parent.Send() <- Message{Val: "bar"}
+		parent.Send() <- Message{Val: "baz"}
+	})
+
+	waitObserved(t, observed, "baz")
+	joinSession(t, parent, child, l, false)
+	testTimeout(t, func() {
+		for _, v := range []string{"bar", "baz"} {
+			if m := <-child.Receive(); m.Val != v {
+				t.Error("invalid message", m.Val, v)
+			}
+		}
+	})
+
+	expectNoMessage(t, child)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	parent, child, l, _ := createSession(t, 15*time.Millisecond)
+	breakConn := joinSession(t, parent, child, l, true)
+	disconnectSession(t, parent, child, breakConn)
+	testTimeout(t, func() { parent.Send() <- Message{Val: "foo"} })
+
+	time.Sleep(45 * time.Millisecond)
+	joinSession(t, parent, child, l, true)
+	expectNoMessage(t, child)
+}
+
+func TestSessionBuffer(t *testing.T) {
+	ss := newSessions(time.Second, 2)
+	c := make(chan *connControl)
+	ss.attach(c, "foo")
+
+	var oms []*outgoingMessage
+	for i := 0; i < 3; i++ {
+		om := &outgoingMessage{message: &Message{}}
+		oms = append(oms, om)
+		ss.record(om, c)
+	}
+
+	ss.detach(c, interests{{"bar"}}, time.Now())
+	ss.recordDetached(&outgoingMessage{message: &Message{Key: []string{"baz"}}})
+	replay, previous := ss.attach(make(chan *connControl), "foo")
+	if previous != nil || len(replay) != 2 || replay[0] != oms[1] || replay[1] != oms[2] {
+		t.Error("invalid replay", len(replay))
+	}
+
+	ss.detach(c, nil, time.Now())
+	ss.expire(time.Now().Add(2 * time.Second))
+	if len(ss.byToken) != 1 || ss.detached != 0 {
+		t.Error("invalid sessions")
+	}
+}
@@ -0,0 +1,163 @@
+package cast
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// sends the messages from the node to a fast and a slow child, and
+// returns after the fast child received them
+func createDrainingNode(t *testing.T, count int) (Node, Connection) {
+	n := NewNodeWithOptions(NodeOpt{MessageBuffer: count})
+	l := make(InProcListener)
+	n.Listen(l)
+
+	fast, _ := l.Connect()
+	slow, _ := l.Connect()
+	testTimeout(t, func() {
+		for i := 0; i < count; i++ {
+			n.Send() <- Message{}
+			<-fast.Receive()
+		}
+	})
+
+	return n, slow
+}
+
+func expectClosed(t *testing.T, c Connection) {
+	testTimeout(t, func() {
+		for range c.Receive() {
+		}
+	})
+}
+
+func TestShutdownFlushes(t *testing.T) {
+	n, slow := createDrainingNode(t, 3)
+
+	done := make(chan struct{})
+	go func() {
+		if abandoned, err := n.(Shutdowner).Shutdown(context.Background()); abandoned != 0 || err != nil {
+			t.Error("failed to flush messages", abandoned, err)
+		}
+
+		close(done)
+	}()
+
+	testTimeout(t, func() {
+		for i := 0; i < 3; i++ {
+			if _, open := <-slow.Receive(); !open {
+				t.Fatal("connection closed before flushing")
+			}
+		}
+	})
+
+	expectClosed(t, slow)
+	expectClosed(t, n)
+	testTimeout(t, func() { <-done })
+}
+
+func TestShutdownAbandons(t *testing.T) {
+	n, slow := createDrainingNode(t, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+
+	abandoned, err := n.(Shutdowner).Shutdown(ctx)
+	if abandoned != 3 || err != context.DeadlineExceeded {
+		t.Error("invalid shutdown result", abandoned, err)
+	}
+
+	expectClosed(t, slow)
+	expectClosed(t, n)
+}
+
+func TestShutdownClosed(t *testing.T) {
+	n := NewNode(0, 0)
+	if _, err := n.(Shutdowner).Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := n.(Shutdowner).Shutdown(context.Background()); err != ErrClosed {
+		t.Error("invalid error", err)
+	}
+
+	n.Join(make(MessageChannel))
+}
+
+func TestNodeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := NewNodeContext(ctx, NodeOpt{})
+	l := make(InProcListener)
+	n.Listen(l)
+	child, _ := l.Connect()
+
+	cancel()
+	expectClosed(t, child)
+	expectClosed(t, n)
+}
+
+func TestShutdownClosesJoined(t *testing.T) {
+	n := NewNode(0, 0)
+	if _, err := n.(Shutdowner).Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	local, remote := NewInProcConnection()
+	n.Join(local)
+	expectClosed(t, remote)
+}
+
+func TestRecoveryShutdownStopsRecovering(t *testing.T) {
+	l := make(InProcListener)
+	p := NewNode(0, 0)
+	p.Listen(l)
+	go receiveAll(p)
+
+	original := &failingInterface{}
+	original.fail.Store(1 << 30)
+	other := &failingInterface{iface: l}
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:          []Interface{original, other},
+		Strategy:         StrategyFailBack,
+		FailBackInterval: time.Millisecond})
+
+	testTimeout(t, func() {
+		for e := range n.(EventSource).Events() {
+			if e.Type == FailBack || e.Type == ReconnectFailed && e.Interface == original && e.Backoff > 0 {
+				break
+			}
+		}
+	})
+
+	if abandoned, err := n.(Shutdowner).Shutdown(context.Background()); abandoned != 0 || err != nil {
+		t.Error("invalid shutdown result", abandoned, err)
+	}
+
+	expectClosed(t, n)
+	attempts := original.fail.Load()
+	time.Sleep(15 * time.Millisecond)
+	if original.fail.Load() != attempts {
+		t.Error("failed to stop recovering")
+	}
+
+	if _, err := n.(Shutdowner).Shutdown(context.Background()); err != ErrClosed {
+		t.Error("invalid error", err)
+	}
+}
+
+func TestRecoveryShutdownAbandonsStored(t *testing.T) {
+	fi := &failingInterface{}
+	fi.fail.Store(1 << 30)
+	n := NewRecoveryNode(RecoveryOpt{Parents: []Interface{fi}, StoreCount: 5})
+	testTimeout(t, func() {
+		for i := 0; i < 3; i++ {
+			n.Send() <- Message{}
+		}
+	})
+
+	if abandoned, err := n.(Shutdowner).Shutdown(context.Background()); abandoned != 3 || err != nil {
+		t.Error("invalid shutdown result", abandoned, err)
+	}
+
+	expectClosed(t, n)
+}
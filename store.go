@@ -0,0 +1,88 @@
+package cast
+
+import "time"
+
+type storedMessage struct {
+	message  Message
+	size     int
+	deadline time.Time
+}
+
+// holds the messages sent by the application while a recovery node has
+// no parent. when a limit is exceeded, the oldest messages are dropped. a
+// store without limits is disabled.
+type store struct {
+	maxCount int
+	maxBytes int
+	maxAge   time.Duration
+	messages []storedMessage
+	bytes    int
+}
+
+func newStore(maxCount, maxBytes int, maxAge time.Duration) *store {
+	return &store{maxCount: maxCount, maxBytes: maxBytes, maxAge: maxAge}
+}
+
+func messageSize(m Message) int {
+	size := len(m.Val) + len(m.Comment)
+	for _, k := range m.Key {
+		size += len(k)
+	}
+
+	return size
+}
+
+func (s *store) enabled() bool {
+	return s.maxCount > 0 || s.maxBytes > 0 || s.maxAge > 0
+}
+
+func (s *store) empty() bool { return len(s.messages) == 0 }
+
+func (s *store) over() bool {
+	return s.maxCount > 0 && len(s.messages) > s.maxCount ||
+		s.maxBytes > 0 && s.bytes > s.maxBytes
+}
+
+func (s *store) pop() Message {
+	sm := s.messages[0]
+	s.messages[0] = storedMessage{}
+	s.messages = s.messages[1:]
+	s.bytes -= sm.size
+	return sm.message
+}
+
+// drops the stored messages, and returns their count
+func (s *store) clear() int {
+	count := len(s.messages)
+	s.messages, s.bytes = nil, 0
+	return count
+}
+
+// stores a message, and returns the messages dropped due to the count or
+// the size limit
+func (s *store) push(m Message, now time.Time) []Message {
+	sm := storedMessage{message: m, size: messageSize(m)}
+	if s.maxAge > 0 {
+		sm.deadline = now.Add(s.maxAge)
+	}
+
+	s.messages = append(s.messages, sm)
+	s.bytes += sm.size
+
+	var dropped []Message
+	for s.over() {
+		dropped = append(dropped, s.pop())
+	}
+
+	return dropped
+}
+
+// drops and returns the messages whose deadline passed
+func (s *store) expire(now time.Time) []Message {
+	var expired []Message
+	for s.maxAge > 0 && !s.empty() && !now.Before(s.messages[0].deadline) {
+		expired = append(expired, s.pop())
+	}
+
+	return expired
+}
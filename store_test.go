@@ -0,0 +1,92 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreLimits(t *testing.T) {
+	now := time.Now()
+	s := newStore(3, 5, time.Second)
+	s.push(Message{Val: "a"}, now)
+	s.push(Message{Val: "b"}, now)
+	s.push(Message{Val: "c"}, now.Add(time.Millisecond))
+	if dropped := s.push(Message{Val: "d"}, now.Add(time.Millisecond)); len(dropped) != 1 || dropped[0].Val != "a" {
+		t.Error("failed to apply the count limit", dropped)
+	}
+
+	if dropped := s.push(Message{Val: "ee"}, now.Add(time.Millisecond)); len(dropped) != 1 || dropped[0].Val != "b" {
+		t.Error("failed to apply the size limit", dropped)
+	}
+
+	if expired := s.expire(now.Add(time.Second)); len(expired) != 0 {
+		t.Error("unexpected expiry", expired)
+	}
+
+	if expired := s.expire(now.Add(time.Second + time.Millisecond)); len(expired) != 3 || !s.empty() {
+		t.Error("failed to expire messages", expired)
+	}
+
+	if newStore(0, 0, 0).enabled() {
+		t.Error("store without limits enabled")
+	}
+}
+
+func TestRecoveryStoresMessages(t *testing.T) {
+	p := NewNode(0, 0)
+	l := make(InProcListener)
+	p.Listen(l)
+
+	fi := &failingInterface{iface: l}
+	fi.fail.Store(2)
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:        []Interface{fi},
+		InitialBackoff: 9 * time.Millisecond,
+		StoreCount:     3})
+
+	testTimeout(t, func() {
+		for _, v := range []string{"foo", "bar", "baz"} {
+			n.Send() <- Message{Val: v}
+		}
+
+		for _, v := range []string{"foo", "bar", "baz"} {
+			if m := <-p.Receive(); m.Val != v {
+				t.Error("invalid message", m.Val, v)
+			}
+		}
+	})
+}
+
+func TestRecoveryStoreOverflow(t *testing.T) {
+	fi := &failingInterface{}
+	fi.fail.Store(1 << 30)
+	n := NewRecoveryNode(RecoveryOpt{Parents: []Interface{fi}, StoreCount: 1})
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Key: []string{"foo"}}
+		n.Send() <- Message{Key: []string{"bar"}}
+	})
+
+	var oe *OverflowError
+	ne := receiveNodeError(t, n)
+	if ne.Kind != ErrorOverflow || !errors.As(ne, &oe) || oe.Message.Key[0] != "foo" {
+		t.Error("invalid error", ne)
+	}
+}
+
+func TestRecoveryStoreExpiry(t *testing.T) {
+	fi := &failingInterface{}
+	fi.fail.Store(1 << 30)
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:  []Interface{fi},
+		StoreAge: 15 * time.Millisecond})
+
+	testTimeout(t, func() { n.Send() <- Message{Key: []string{"foo"}} })
+
+	var terr *TimeoutError
+	ne := receiveNodeError(t, n)
+	if ne.Kind != ErrorTimeout || !errors.As(ne, &terr) || ne.Key[0] != "foo" {
+		t.Error("invalid error", ne)
+	}
+}
@@ -0,0 +1,73 @@
+package cast
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// the default period of checking whether the original parent is
+	// available again, with StrategyFailBack
+	DefaultFailBackInterval = 10 * time.Second
+
+	// the default time while the candidate parents are probed, with
+	// StrategyLeastLatency
+	DefaultProbeTimeout = 3 * time.Second
+)
+
+// error reported with ReconnectFailed, when a candidate parent didn't
+// connect during the probe timeout
+var ErrProbeTimeout = errors.New("probe timeout")
+
+// defines the order in which a recovery node tries the candidate parents
+type ParentStrategy int
+
+const (
+	// the candidates are tried in order, starting with the one after the
+	// last parent
+	StrategyOrdered ParentStrategy = iota
+
+	// the candidates are tried in random order
+	StrategyRandom
+
+	// every candidate is probed in parallel by connecting to it, and the
+	// one that connects first is selected. the other connections are
+	// closed.
+	StrategyLeastLatency
+
+	// the candidates are always tried in order, and while the node is
+	// connected to another parent than the first candidate, it checks
+	// periodically whether the first candidate is available again, and
+	// fails back to it
+	StrategyFailBack
+)
+
+func (s ParentStrategy) String() string {
+	switch s {
+	case StrategyOrdered:
+		return "ordered"
+	case StrategyRandom:
+		return "random"
+	case StrategyLeastLatency:
+		return "least latency"
+	case StrategyFailBack:
+		return "fail back"
+	default:
+		return "unknown"
+	}
+}
+
+// returns the indexes of the candidates in the order they are tried
+func candidateOrder(s ParentStrategy, count int) []int {
+	if s == StrategyRandom {
+		return rand.Perm(count)
+	}
+
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+
+	return order
+}
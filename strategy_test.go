@@ -0,0 +1,185 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// delays the connection attempts
+type slowInterface struct {
+	iface Interface
+	delay time.Duration
+}
+
+func (i *slowInterface) Connect() (Connection, error) {
+	time.Sleep(i.delay)
+	return i.iface.Connect()
+}
+
+func listeningNode() (Node, InProcListener) {
+	n := NewNode(0, 0)
+	l := make(InProcListener)
+	n.Listen(l)
+	return n, l
+}
+
+// receives the events of a recovery node, skipping the ones of the other
+// types
+func expectStrategyEvent(t *testing.T, n Node, typ EventType) Event {
+	var e Event
+	testTimeout(t, func() {
+		for e = range n.(EventSource).Events() {
+			if e.Type == typ {
+				return
+			}
+		}
+	})
+
+	return e
+}
+
+func TestCandidateOrder(t *testing.T) {
+	for _, s := range []ParentStrategy{StrategyOrdered, StrategyFailBack, StrategyRandom} {
+		order := candidateOrder(s, 5)
+		seen := make(map[int]bool)
+		for i, ci := range order {
+			if s != StrategyRandom && ci != i {
+				t.Error("invalid order", s, order)
+			}
+
+			seen[ci] = true
+		}
+
+		if len(order) != 5 || len(seen) != 5 {
+			t.Error("invalid candidates", s, order)
+		}
+	}
+}
+
+func TestStrategyRandom(t *testing.T) {
+	p1, l1 := listeningNode()
+	defer close(p1.Send())
+	p2, l2 := listeningNode()
+	defer close(p2.Send())
+
+	selected := make(map[Interface]int)
+	for i := 0; i < 30; i++ {
+		n := NewRecoveryNode(RecoveryOpt{
+			Parents:  []Interface{l1, l2},
+			Strategy: StrategyRandom})
+		e := expectStrategyEvent(t, n, ParentSelected)
+		if e.Strategy != StrategyRandom {
+			t.Error("invalid strategy", e.Strategy)
+		}
+
+		selected[e.Interface]++
+		close(n.Send())
+	}
+
+	if selected[l1] == 0 || selected[l2] == 0 {
+		t.Error("failed to select randomly", selected[l1], selected[l2])
+	}
+}
+
+func TestStrategyLeastLatency(t *testing.T) {
+	p1, l1 := listeningNode()
+	defer close(p1.Send())
+	p2, l2 := listeningNode()
+	defer close(p2.Send())
+
+	slow := &slowInterface{iface: l1, delay: 15 * time.Millisecond}
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:  []Interface{slow, l2},
+		Strategy: StrategyLeastLatency})
+	defer close(n.Send())
+
+	e := expectStrategyEvent(t, n, ParentSelected)
+	if e.Interface != l2 || e.Strategy != StrategyLeastLatency || e.Latency >= slow.delay {
+		t.Error("invalid selection", e.Interface, e.Latency)
+	}
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		if m := <-p2.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestStrategyLeastLatencyClosesProbes(t *testing.T) {
+	p1, l1 := listeningNode()
+	defer close(p1.Send())
+	p2, l2 := listeningNode()
+	defer close(p2.Send())
+
+	slow := &slowInterface{iface: l1, delay: 15 * time.Millisecond}
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:  []Interface{slow, l2},
+		Strategy: StrategyLeastLatency})
+	defer close(n.Send())
+
+	if e := expectStrategyEvent(t, n, ParentSelected); e.Interface != l2 {
+		t.Error("invalid selection", e.Interface)
+	}
+
+	expectStrategyEvent(t, p1, ChildConnected)
+	expectStrategyEvent(t, p1, ChildDisconnected)
+}
+
+func TestStrategyLeastLatencyTimeout(t *testing.T) {
+	p, l := listeningNode()
+	defer close(p.Send())
+
+	slow := &slowInterface{iface: l, delay: 60 * time.Millisecond}
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:      []Interface{slow},
+		Strategy:     StrategyLeastLatency,
+		ProbeTimeout: 15 * time.Millisecond})
+	defer close(n.Send())
+
+	start := time.Now()
+	e := expectStrategyEvent(t, n, ReconnectFailed)
+	if e.Interface != slow || !errors.Is(e.Err, ErrProbeTimeout) || time.Since(start) >= slow.delay {
+		t.Error("invalid failed attempt", e.Interface, e.Err)
+	}
+}
+
+func TestStrategyFailBack(t *testing.T) {
+	p1, l1 := listeningNode()
+	defer close(p1.Send())
+	p2, l2 := listeningNode()
+	defer close(p2.Send())
+
+	original := &failingInterface{iface: l1}
+	original.fail.Store(2)
+	n := NewRecoveryNode(RecoveryOpt{
+		Parents:          []Interface{original, l2},
+		Strategy:         StrategyFailBack,
+		FailBackInterval: 6 * time.Millisecond})
+	defer close(n.Send())
+
+	if e := expectStrategyEvent(t, n, ParentSelected); e.Interface != l2 {
+		t.Error("invalid selection", e.Interface)
+	}
+
+	e := expectStrategyEvent(t, n, ReconnectFailed)
+	if e.Interface != original || e.Backoff != 6*time.Millisecond {
+		t.Error("invalid failed attempt", e.Interface, e.Backoff)
+	}
+
+	if e := expectStrategyEvent(t, n, FailBack); e.Interface != original || e.Strategy != StrategyFailBack {
+		t.Error("invalid fail back", e.Interface, e.Strategy)
+	}
+
+	if e := expectStrategyEvent(t, n, ParentChanged); e.Connection == nil || e.Previous == nil {
+		t.Error("failed to replace the parent")
+	}
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		if m := <-p1.Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+	})
+}
@@ -0,0 +1,348 @@
+package cast
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// connection over a byte stream
+type StreamConnection interface {
+	Connection
+
+	// reports the errors of encoding and decoding the messages. the
+	// errors are buffered, and they don't need to be received.
+	Error() <-chan error
+}
+
+type streamConnection struct {
+	send       chan Message
+	receive    chan Message
+	closed     chan struct{}
+	err        chan error
+	announce   chan struct{}
+	remote     chan struct{}
+	remoteOnce sync.Once
+	stream     io.ReadWriteCloser
+	codec      Codec
+}
+
+type pipe struct {
+	io.ReadCloser
+	writer io.WriteCloser
+}
+
+// turns a byte stream into a connection, using the codec as the wire
+// format
+// takes ownership of the stream regarding closing
+// closing send closes the stream
+// receive is closed when the end of the stream is reached, or the
+// stream cannot be read anymore
+// a malformed frame is reported as an error, and closes receive, because
+// the position of the next frame in the stream is unknown
+func NewStreamConnection(s io.ReadWriteCloser, codec Codec) StreamConnection {
+	return newStreamConnection(s, codec, false, false)
+}
+
+// like NewStreamConnection, but for linking two nodes: the node using the
+// connection announces itself to the remote end, so that the two nodes
+// exchange the messages with the envelope. both ends of the stream need
+// to be created this way. until the announcement of the remote end
+// arrives, the remote end is handled as a connection that is not a node.
+func NewNodeStreamConnection(s io.ReadWriteCloser, codec Codec) StreamConnection {
+	return newStreamConnection(s, codec, true, false)
+}
+
+// only the connecting end of a stream that passed the handshake announces
+// a node joining through it, because the remote end is a listener, that is
+// known to be a node. the accepting end learns from the announcement that
+// the remote end is a node. this way, the other streams, and the clients
+// that are not nodes, never receive the announcement. the streams linking
+// two nodes announce from both ends.
+func newStreamConnection(s io.ReadWriteCloser, codec Codec, announce, remoteNode bool) *streamConnection {
+	c := &streamConnection{
+		send:    make(chan Message),
+		receive: make(chan Message),
+		closed:  make(chan struct{}),
+		err:     make(chan error, streamErrorBuffer),
+		remote:  make(chan struct{}),
+		stream:  s,
+		codec:   codec}
+	if announce {
+		c.announce = make(chan struct{})
+	}
+
+	if remoteNode {
+		c.setRemoteNode()
+	}
+
+	go c.writeStream()
+	go c.readStream()
+	return c
+}
+
+// creates a stream connection from separate reader and writer ends,
+// e.g. from os.Stdin and os.Stdout, or from the pipes of a subprocess
+// closing send closes both the reader and the writer
+func NewPipeConnection(r io.ReadCloser, w io.WriteCloser, codec Codec) StreamConnection {
+	return NewStreamConnection(&pipe{r, w}, codec)
+}
+
+// like NewPipeConnection, but for linking two nodes, see
+// NewNodeStreamConnection
+func NewNodePipeConnection(r io.ReadCloser, w io.WriteCloser, codec Codec) StreamConnection {
+	return NewNodeStreamConnection(&pipe{r, w}, codec)
+}
+
+func (p *pipe) Write(b []byte) (int, error) { return p.writer.Write(b) }
+
+func (p *pipe) Close() error {
+	werr := p.writer.Close()
+	rerr := p.ReadCloser.Close()
+	if werr != nil {
+		return werr
+	}
+
+	return rerr
+}
+
+// the writer and the reader report at most one error each, so the buffer
+// of the error channel doesn't fill up, and the errors don't need to be
+// received
+const streamErrorBuffer = 2
+
+func (c *streamConnection) reportError(err error) {
+	select {
+	case c.err <- err:
+	default:
+	}
+}
+
+// encodes the sent messages to the stream. when writing fails, the
+// error is reported, the stream is closed, and the sent messages are
+// discarded until send is closed. the announcement of the local node is
+// written in order with the sent messages.
+func (c *streamConnection) writeStream() {
+	enc := c.codec.NewEncoder(c.stream)
+	failed := false
+	for {
+		var m Message
+		select {
+		case sm, open := <-c.send:
+			if !open {
+				close(c.closed)
+				if !failed {
+					c.stream.Close()
+				}
+
+				return
+			}
+
+			m = sm
+		case <-c.announce:
+			m = wrap(Message{}, &envelope{control: controlNode})
+		}
+
+		if failed {
+			continue
+		}
+
+		if err := enc.Encode(m); err != nil {
+			failed = true
+			c.reportError(err)
+			c.stream.Close()
+		}
+	}
+}
+
+// decodes the incoming messages from the stream until it fails or the
+// connection gets closed. the end of the stream, and the read errors
+// caused by closing the stream from this side, are not reported.
+func (c *streamConnection) readStream() {
+	defer close(c.receive)
+	dec := c.codec.NewDecoder(c.stream)
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			select {
+			case <-c.closed:
+			default:
+				c.reportError(err)
+			}
+
+			return
+		}
+
+		if isNodeAnnouncement(m) {
+			c.setRemoteNode()
+			continue
+		}
+
+		select {
+		case c.receive <- m:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *streamConnection) Send() chan<- Message    { return c.send }
+func (c *streamConnection) Receive() <-chan Message { return c.receive }
+func (c *streamConnection) Error() <-chan error     { return c.err }
+
+// tells the remote end that the local end is a node, without sending a
+// message to it
+func (c *streamConnection) announceNode() {
+	if c.announce == nil {
+		return
+	}
+
+	select {
+	case c.announce <- struct{}{}:
+	case <-c.closed:
+	}
+}
+
+func (c *streamConnection) remoteNode() <-chan struct{} { return c.remote }
+
+func (c *streamConnection) setRemoteNode() {
+	c.remoteOnce.Do(func() { close(c.remote) })
+}
+
+// the address of the remote end, when the stream is a socket, otherwise
+// nil
+func (c *streamConnection) RemoteAddr() net.Addr {
+	if rc, ok := c.stream.(remoteAddrSource); ok {
+		return rc.RemoteAddr()
+	}
+
+	return nil
+}
+
+// the handshake at the start of a stream connection
+//
+// the connecting side sends the protocol version and the names of the
+// codecs that it supports, in the order of preference, in a single
+// line, separated by spaces. the accepting side responds with the
+// protocol version and the name of the first codec in the proposed list
+// that it supports, or '-' when none of them.
+const (
+	handshakeVersion = "cast/1"
+	maxHandshakeLine = 512
+)
+
+var (
+	// error returned when the remote end doesn't respond with a valid
+	// handshake
+	ErrInvalidHandshake = errors.New("invalid handshake")
+
+	// error returned when the two ends of a stream don't support a
+	// common codec
+	ErrNoCommonCodec = errors.New("no common codec")
+)
+
+// reads a single line without reading ahead, so that the rest of the
+// stream can be passed to the decoder
+func readHandshakeLine(r io.Reader) ([]string, error) {
+	var (
+		l []byte
+		b [1]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+
+		if b[0] == '\n' {
+			break
+		}
+
+		if len(l) == maxHandshakeLine {
+			return nil, ErrInvalidHandshake
+		}
+
+		l = append(l, b[0])
+	}
+
+	f := strings.Fields(string(l))
+	if len(f) < 2 || f[0] != handshakeVersion {
+		return nil, ErrInvalidHandshake
+	}
+
+	return f[1:], nil
+}
+
+func findCodec(codecs []Codec, name string) Codec {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// proposes the codecs, and returns the one selected by the remote end
+func proposeCodec(rw io.ReadWriter, codecs []Codec) (Codec, error) {
+	names := strings.Join(codecNames(codecs), " ")
+	if _, err := fmt.Fprintf(rw, "%s %s\n", handshakeVersion, names); err != nil {
+		return nil, err
+	}
+
+	f, err := readHandshakeLine(rw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f) != 1 {
+		return nil, ErrInvalidHandshake
+	}
+
+	if f[0] == "-" {
+		return nil, ErrNoCommonCodec
+	}
+
+	c := findCodec(codecs, f[0])
+	if c == nil {
+		return nil, ErrInvalidHandshake
+	}
+
+	return c, nil
+}
+
+// selects the first proposed codec that is supported, and reports the
+// selection to the remote end
+func acceptCodec(rw io.ReadWriter, codecs []Codec) (Codec, error) {
+	f, err := readHandshakeLine(rw)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Codec
+	for _, name := range f {
+		if c = findCodec(codecs, name); c != nil {
+			break
+		}
+	}
+
+	name := "-"
+	if c != nil {
+		name = c.Name()
+	}
+
+	if _, err := fmt.Fprintf(rw, "%s %s\n", handshakeVersion, name); err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		return nil, ErrNoCommonCodec
+	}
+
+	return c, nil
+}
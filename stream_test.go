@@ -0,0 +1,245 @@
+package cast
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func testHandshake(propose, accept []Codec) (Codec, Codec, error, error) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	var (
+		acceptCodecResult Codec
+		acceptErr         error
+		done              = make(chan struct{})
+	)
+
+	go func() {
+		acceptCodecResult, acceptErr = acceptCodec(right, accept)
+		close(done)
+	}()
+
+	c, err := proposeCodec(left, propose)
+	<-done
+	return c, acceptCodecResult, err, acceptErr
+}
+
+func TestHandshake(t *testing.T) {
+	for _, ti := range []struct {
+		msg             string
+		propose, accept []Codec
+		expect          Codec
+	}{{
+		"single", []Codec{TextCodec}, []Codec{TextCodec, BinaryCodec}, TextCodec,
+	}, {
+		"preference", []Codec{BinaryCodec, TextCodec}, []Codec{TextCodec, BinaryCodec}, BinaryCodec,
+	}, {
+		"fallback", []Codec{BinaryCodec, TextCodec}, []Codec{TextCodec}, TextCodec,
+	}, {
+		"no common codec", []Codec{BinaryCodec}, []Codec{TextCodec}, nil,
+	}} {
+		pc, ac, perr, aerr := testHandshake(ti.propose, ti.accept)
+		if ti.expect == nil {
+			if perr != ErrNoCommonCodec || aerr != ErrNoCommonCodec {
+				t.Error(ti.msg, "failed to fail", perr, aerr)
+			}
+
+			continue
+		}
+
+		if perr != nil || aerr != nil {
+			t.Error(ti.msg, perr, aerr)
+			continue
+		}
+
+		if pc != ti.expect || ac != ti.expect {
+			t.Error(ti.msg, "invalid codec selected", pc, ac)
+		}
+	}
+}
+
+func TestInvalidHandshake(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	go func() {
+		readHandshakeLine(right)
+		right.Write([]byte("HTTP/1.1 200 OK\n"))
+		right.Close()
+	}()
+
+	if _, err := proposeCodec(left, []Codec{TextCodec}); err != ErrInvalidHandshake {
+		t.Error("failed to reject handshake", err)
+	}
+}
+
+func TestStreamConnection(t *testing.T) {
+	left, right := net.Pipe()
+	lc := NewStreamConnection(left, BinaryCodec)
+	rc := NewStreamConnection(right, BinaryCodec)
+
+	testTimeout(t, func() {
+		lc.Send() <- Message{Key: []string{"foo"}, Val: "bar"}
+		if m := <-rc.Receive(); m.Val != "bar" {
+			t.Error("failed to receive message", m)
+		}
+	})
+
+	close(lc.Send())
+	testTimeout(t, func() {
+		if _, open := <-rc.Receive(); open {
+			t.Error("failed to close receive")
+		}
+
+		if _, open := <-lc.Receive(); open {
+			t.Error("failed to close receive")
+		}
+	})
+}
+
+func TestNodeStreamConnection(t *testing.T) {
+	root := NewNode(0, 0)
+	l := make(InProcListener)
+	root.Listen(l)
+	sibling := NewNode(0, 0)
+	c, _ := l.Connect()
+	sibling.Join(c)
+
+	// the middle node joins the root through a stream
+	left, right := net.Pipe()
+	rootEnd := NewNodeStreamConnection(left, BinaryCodec)
+	midEnd := NewNodeStreamConnection(right, BinaryCodec)
+	mid := NewNode(0, 0)
+	mid.Join(midEnd)
+	l <- rootEnd
+
+	leaf := NewNode(0, 0)
+	midl := make(InProcListener)
+	mid.Listen(midl)
+	c, _ = midl.Connect()
+	leaf.Join(c)
+
+	testTimeout(t, func() {
+		<-rootEnd.(*streamConnection).remoteNode()
+		<-midEnd.(*streamConnection).remoteNode()
+	})
+
+	nodes := []Node{root, sibling, mid, leaf}
+	waitAdvertised(t, nodes)
+
+	testTimeout(t, func() {
+		leaf.Send() <- WithScope(Message{Val: "foo"}, ScopeUp)
+		for _, n := range []Node{mid, root} {
+			if m := <-n.Receive(); m.Val != "foo" {
+				t.Error("invalid message", m)
+			}
+		}
+
+		leaf.(Router).SendTo(root.(Router).ID(), Message{Val: "bar"})
+		if m := <-root.Receive(); m.Val != "bar" {
+			t.Error("invalid message", m)
+		}
+	})
+
+	expectNoMessages(t, nodes)
+}
+
+func TestPipeConnectionEOF(t *testing.T) {
+	r, w := io.Pipe()
+	_, sink := io.Pipe()
+	c := NewPipeConnection(r, sink, TextCodec)
+
+	go func() {
+		w.Write([]byte("foo = bar\n"))
+		w.Close()
+	}()
+
+	testTimeout(t, func() {
+		if m := <-c.Receive(); m.Val != "bar" {
+			t.Error("failed to receive message", m)
+		}
+
+		if _, open := <-c.Receive(); open {
+			t.Error("failed to close receive")
+		}
+	})
+
+	select {
+	case err := <-c.Error():
+		t.Error("unexpected error", err)
+	default:
+	}
+
+	close(c.Send())
+}
+
+func TestPipeConnectionCloseSend(t *testing.T) {
+	source, _ := io.Pipe()
+	r, w := io.Pipe()
+	c := NewPipeConnection(source, w, TextCodec)
+
+	go func() { c.Send() <- Message{Val: "foo"} }()
+
+	dec := NewTextDecoder(r)
+	if m, err := dec.Decode(); err != nil || m.Val != "foo" {
+		t.Error("failed to send message", m, err)
+	}
+
+	close(c.Send())
+	testTimeout(t, func() {
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Error("failed to close stream", err)
+		}
+
+		if _, open := <-c.Receive(); open {
+			t.Error("failed to close receive")
+		}
+	})
+}
+
+func TestPipeConnectionDecodeError(t *testing.T) {
+	r, w := io.Pipe()
+	_, sink := io.Pipe()
+	c := NewPipeConnection(r, sink, TextCodec)
+
+	go w.Write([]byte("foo\n"))
+
+	testTimeout(t, func() {
+		if _, ok := (<-c.Error()).(*FrameError); !ok {
+			t.Error("failed to report decode error")
+		}
+
+		if _, open := <-c.Receive(); open {
+			t.Error("failed to close receive")
+		}
+	})
+
+	close(c.Send())
+}
+
+func TestPipeConnectionErrorBuffered(t *testing.T) {
+	r, w := io.Pipe()
+	_, sink := io.Pipe()
+	c := NewPipeConnection(r, sink, TextCodec)
+
+	go w.Write([]byte("foo\n"))
+
+	testTimeout(t, func() {
+		if _, open := <-c.Receive(); open {
+			t.Error("failed to close receive")
+		}
+	})
+
+	select {
+	case err := <-c.Error():
+		if _, ok := err.(*FrameError); !ok {
+			t.Error("invalid error", err)
+		}
+	default:
+		t.Error("failed to buffer decode error")
+	}
+
+	close(c.Send())
+}
@@ -0,0 +1,232 @@
+package cast
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterestsNormalize(t *testing.T) {
+	is := interests{{"foo", "bar"}, {"baz"}, {"foo"}, {"baz", "qux"}}.normalize()
+	if len(is) != 2 || !messagesEqual(Message{Key: is[0]}, Message{Key: []string{"baz"}}) ||
+		!messagesEqual(Message{Key: is[1]}, Message{Key: []string{"foo"}}) {
+		t.Error("failed to normalize interests", is)
+	}
+
+	if is := (interests{{"foo"}, {}}).normalize(); is != nil {
+		t.Error("failed to normalize interest in every message", is)
+	}
+}
+
+func TestInterestsEncoding(t *testing.T) {
+	for _, is := range []interests{
+		nil,
+		{{"foo"}},
+		{{"baz"}, {"foo", "bar"}},
+	} {
+		d := decodeInterests(encodeInterests(is))
+		if len(d) != len(is) || (is == nil) != (d == nil) {
+			t.Error("failed to decode interests", is, d)
+			continue
+		}
+
+		for i := range is {
+			if !messagesEqual(Message{Key: is[i]}, Message{Key: d[i]}) {
+				t.Error("failed to decode interests", is, d)
+			}
+		}
+	}
+
+	if d := decodeInterests(encodeInterests(interests{})); d == nil || len(d) != 0 {
+		t.Error("failed to decode interest in no message", d)
+	}
+
+	if d := decodeInterests("foo"); d != nil {
+		t.Error("failed to handle invalid interests", d)
+	}
+}
+
+// subscribes the leaves in the tree, and waits until the subscription
+// reaches the root
+func subscribeLeaves(t *testing.T, nodes []Node, leaves []Node, prefix []string) {
+	for _, l := range leaves {
+		l.(Subscriber).Subscribe(prefix)
+	}
+
+	waitAdvertised(t, nodes)
+}
+
+func receiveAvailable(c Connection) {
+	for {
+		select {
+		case <-c.Receive():
+		case <-time.After(15 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	nodes := createRoutedTree(t, 13)
+	leaves := []Node{nodes[2], nodes[3], nodes[4], nodes[6], nodes[7], nodes[8], nodes[10], nodes[11], nodes[12]}
+	subscribeLeaves(t, nodes, leaves, []string{"service", "billing"})
+	nodes[6].(Subscriber).Subscribe([]string{"config"})
+
+	testTimeout(t, func() {
+		nodes[0].Send() <- Message{Key: []string{"service", "billing", "invoice"}, Val: "foo"}
+		for _, n := range append([]Node{nodes[1], nodes[5], nodes[9]}, leaves...) {
+			if m := <-n.Receive(); m.Val != "foo" {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	testTimeout(t, func() {
+		nodes[0].Send() <- Message{Key: []string{"config"}, Val: "bar"}
+		for _, n := range []Node{nodes[1], nodes[5], nodes[9], nodes[6]} {
+			if m := <-n.Receive(); m.Val != "bar" {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	expectNoMessages(t, nodes)
+}
+
+func TestSubscribeFiltersLocal(t *testing.T) {
+	n, parent, _, _ := createTestNode(0, 0, true, 0)
+	n.(Subscriber).Subscribe([]string{"foo"})
+	testTimeout(t, func() {
+		parent.Send() <- Message{Key: []string{"bar"}}
+		parent.Send() <- Message{Key: []string{"foo", "bar"}, Val: "baz"}
+		if m := <-n.Receive(); m.Val != "baz" {
+			t.Error("invalid message", m)
+		}
+	})
+
+	n.(Subscriber).Unsubscribe([]string{"foo"})
+	testTimeout(t, func() {
+		parent.Send() <- Message{Key: []string{"bar"}, Val: "qux"}
+		if m := <-n.Receive(); m.Val != "qux" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestSubscribeUpward(t *testing.T) {
+	nodes := createRoutedTree(t, 4)
+	subscribeLeaves(t, nodes, nodes[2:], []string{"foo"})
+
+	testTimeout(t, func() {
+		nodes[2].Send() <- Message{Key: []string{"bar"}}
+		<-nodes[1].Receive()
+		<-nodes[0].Receive()
+	})
+
+	expectNoMessages(t, nodes)
+}
+
+func TestSubscribeRawChildren(t *testing.T) {
+	n, _, _, children := createTestNode(0, 0, false, 2)
+	n.(Subscriber).Subscribe([]string{"foo"})
+	testTimeout(t, func() {
+		children[0].Send() <- Message{Key: []string{"bar"}}
+		<-children[1].Receive()
+	})
+
+	expectNoMessage(t, n)
+}
+
+// records the values of the messages received through an in-process
+// connection, keeping it known to be a node
+type tapConnection struct {
+	*inProcConnection
+	receive chan Message
+	mx      sync.Mutex
+	values  []string
+}
+
+func connectTapped(l InProcListener) *tapConnection {
+	c, _ := l.Connect()
+	t := &tapConnection{inProcConnection: c.(*inProcConnection), receive: make(chan Message)}
+	go func() {
+		for m := range t.inProcConnection.Receive() {
+			t.mx.Lock()
+			t.values = append(t.values, m.Val)
+			t.mx.Unlock()
+			t.receive <- m
+		}
+
+		close(t.receive)
+	}()
+
+	return t
+}
+
+func (t *tapConnection) Receive() <-chan Message { return t.receive }
+
+func (t *tapConnection) received(val string) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, v := range t.values {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSubscribeRelays(t *testing.T) {
+	root := NewNode(0, 0)
+	l := make(InProcListener)
+	root.Listen(l)
+	nodes := []Node{root}
+
+	// two branches, with two relays in each, and a leaf at the end
+	var taps []*tapConnection
+	for i := 0; i < 2; i++ {
+		relay1 := NewNodeWithOptions(NodeOpt{Relay: true})
+		tap := connectTapped(l)
+		relay1.Join(tap)
+		taps = append(taps, tap)
+
+		relay2 := NewNodeWithOptions(NodeOpt{Relay: true})
+		l1 := make(InProcListener)
+		relay1.Listen(l1)
+		c, _ := l1.Connect()
+		relay2.Join(c)
+
+		leaf := NewNode(0, 0)
+		l2 := make(InProcListener)
+		relay2.Listen(l2)
+		c, _ = l2.Connect()
+		leaf.Join(c)
+
+		nodes = append(nodes, relay1, relay2, leaf)
+	}
+
+	subscribeLeaves(t, nodes, []Node{nodes[3]}, []string{"foo"})
+	subscribeLeaves(t, nodes, []Node{nodes[6]}, []string{"bar"})
+
+	testTimeout(t, func() {
+		root.Send() <- Message{Key: []string{"foo"}, Val: "foo"}
+		if m := <-nodes[3].Receive(); m.Val != "foo" {
+			t.Error("invalid message", m)
+		}
+
+		root.Send() <- Message{Key: []string{"bar"}, Val: "bar"}
+		if m := <-nodes[6].Receive(); m.Val != "bar" {
+			t.Error("invalid message", m)
+		}
+	})
+
+	expectNoMessages(t, nodes)
+	if taps[1].received("foo") || taps[0].received("bar") {
+		t.Error("failed to prune the subtree")
+	}
+
+	if !taps[0].received("foo") || !taps[1].received("bar") {
+		t.Error("failed to send the message to the subtree")
+	}
+}
@@ -0,0 +1,55 @@
+package cast
+
+import (
+	"net"
+	"net/url"
+)
+
+// represents a TCP address where it is possible to connect to
+// the codecs are proposed in the order of preference during the
+// handshake. when no codecs are set, the text codec is used.
+type TCPInterface struct {
+	Address string
+	Codecs  []Codec
+}
+
+// listens on a TCP address and provides a connection for each accepted
+// socket
+// stop listening by calling Close
+type TCPListener struct {
+	*streamListener
+}
+
+// dials the address, negotiates the codec, and returns a connection
+// representing the socket
+// closing send closes the socket
+// receive is closed when the remote end hangs up
+func (i TCPInterface) Connect() (Connection, error) {
+	return dialStream("tcp", i.Address, i.Codecs)
+}
+
+// starts listening on the address
+// when the port in the address is 0, a free port is chosen, that can be
+// checked by calling Addr
+// the listener accepts the codecs proposed by the connecting side if
+// they are in the codecs argument. when no codecs are set, both the text
+// and the binary codecs are accepted.
+func NewTCPListener(address string, codecs ...Codec) (*TCPListener, error) {
+	nl, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCPListener{newStreamListener(nl, codecs)}, nil
+}
+
+// an interface that can be used to connect to the listener
+func (l *TCPListener) Interface() TCPInterface {
+	return TCPInterface{Address: l.Addr().String()}
+}
+
+// the URL representing the interface
+func (i TCPInterface) String() string {
+	u := url.URL{Scheme: "tcp", Host: i.Address, RawQuery: encodeCodecs(i.Codecs)}
+	return u.String()
+}
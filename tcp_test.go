@@ -0,0 +1,168 @@
+package cast
+
+import (
+	"errors"
+	"testing"
+)
+
+func createTCPPair(t *testing.T) (Node, Node, *TCPListener) {
+	l, err := NewTCPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := NewNode(0, 0)
+	parent.Listen(l)
+
+	c, err := l.Interface().Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewNode(0, 0)
+	child.Join(c)
+	return parent, child, l
+}
+
+func TestTCPMessaging(t *testing.T) {
+	parent, child, l := createTCPPair(t)
+	defer l.Close()
+
+	testTimeout(t, func() {
+		parent.Send() <- Message{Key: []string{"foo", "bar"}, Val: "baz", Comment: "qux"}
+		m := <-child.Receive()
+		if len(m.Key) != 2 || m.Key[0] != "foo" || m.Key[1] != "bar" ||
+			m.Val != "baz" || m.Comment != "qux" {
+			t.Error("failed to receive message", m)
+		}
+	})
+
+	testTimeout(t, func() {
+		child.Send() <- Message{Val: "Hello, world!"}
+		if m := <-parent.Receive(); m.Val != "Hello, world!" {
+			t.Error("failed to receive message", m)
+		}
+	})
+}
+
+func TestTCPRawClient(t *testing.T) {
+	l, err := NewTCPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	n := NewNode(0, 0)
+	n.Listen(l)
+
+	c, err := l.Interface().Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTimeout(t, func() {
+		n.Send() <- Message{Key: []string{"foo", "bar"}}
+		m := <-c.Receive()
+		if len(m.Key) != 2 || m.Key[0] != "foo" || m.Key[1] != "bar" {
+			t.Error("invalid key", m.Key)
+		}
+	})
+
+	testTimeout(t, func() {
+		c.Send() <- Message{Val: "baz"}
+		if m := <-n.Receive(); m.Val != "baz" {
+			t.Error("invalid message", m)
+		}
+	})
+}
+
+func TestTCPRemoteHangup(t *testing.T) {
+	parent, child, l := createTCPPair(t)
+	defer l.Close()
+
+	testTimeout(t, func() {
+		child.Send() <- Message{}
+		<-parent.Receive()
+	})
+
+	close(parent.Send())
+	testTimeout(t, func() {
+		for {
+			if err := <-child.Error(); errors.Is(err, ErrDisconnected) {
+				return
+			}
+		}
+	})
+}
+
+func TestTCPCloseListener(t *testing.T) {
+	_, _, l := createTCPPair(t)
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+
+	testTimeout(t, func() {
+		for {
+			if _, open := <-l.Connections(); !open {
+				return
+			}
+		}
+	})
+
+	if _, err := l.Interface().Connect(); err == nil {
+		t.Error("failed to stop listening")
+	}
+}
+
+func TestTCPConnectFails(t *testing.T) {
+	l, err := NewTCPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := l.Interface()
+	l.Close()
+	if _, err := i.Connect(); err == nil {
+		t.Error("failed to fail")
+	}
+}
+
+func TestTCPBinaryCodec(t *testing.T) {
+	l, err := NewTCPListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	parent := NewNode(0, 0)
+	parent.Listen(l)
+
+	c, err := TCPInterface{Address: l.Addr().String(), Codecs: []Codec{BinaryCodec}}.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewNode(0, 0)
+	child.Join(c)
+
+	testTimeout(t, func() {
+		child.Send() <- Message{Key: []string{"foo"}, Val: "bar\nbaz"}
+		if m := <-parent.Receive(); m.Val != "bar\nbaz" {
+			t.Error("failed to receive message", m)
+		}
+	})
+}
+
+func TestTCPNoCommonCodec(t *testing.T) {
+	l, err := NewTCPListener("127.0.0.1:0", TextCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	if _, err := (TCPInterface{Address: l.Addr().String(), Codecs: []Codec{BinaryCodec}}).Connect(); err != ErrNoCommonCodec {
+		t.Error("failed to fail", err)
+	}
+}
@@ -0,0 +1,158 @@
+package cast
+
+import "time"
+
+const (
+	timerWheelSize = 64
+
+	// the timeouts are split to this many ticks of the wheel
+	timerWheelTicks = 16
+
+	minTimerResolution = time.Millisecond
+)
+
+// a timeout stored in a timer wheel
+type wheelTimer struct {
+	deadline time.Time
+	value    interface{}
+
+	// set when the timer was cancelled or expired
+	done bool
+}
+
+// hashed timer wheel, owned by a single goroutine, that advances it on
+// the ticks of a ticker running with the resolution of the wheel.
+//
+// a timer is stored in the slot that is visited the first time after
+// its deadline. the timers whose deadline is more than a full turn away,
+// stay in their slot until a later visit. the timers expire at most two
+// ticks later than their deadline. cancelled timers are removed when
+// their slot is visited.
+type timerWheel struct {
+	resolution time.Duration
+	slots      [][]*wheelTimer
+	current    int
+	last       time.Time
+	count      int
+}
+
+// returns the resolution of a wheel for timeouts of the given duration
+func timerResolution(timeout time.Duration) time.Duration {
+	r := timeout / timerWheelTicks
+	if r < minTimerResolution {
+		r = minTimerResolution
+	}
+
+	return r
+}
+
+func newTimerWheel(resolution time.Duration) *timerWheel {
+	return &timerWheel{
+		resolution: resolution,
+		slots:      make([][]*wheelTimer, timerWheelSize)}
+}
+
+// stores a timer. now is used as the starting point of the wheel, when
+// it's empty.
+func (w *timerWheel) add(now, deadline time.Time, value interface{}) *wheelTimer {
+	if w.count == 0 {
+		w.last = now
+	}
+
+	t := &wheelTimer{deadline: deadline, value: value}
+	w.insert(t)
+	return t
+}
+
+func (w *timerWheel) insert(t *wheelTimer) {
+	ticks := int((t.deadline.Sub(w.last) + w.resolution - 1) / w.resolution)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	slot := (w.current + ticks) % len(w.slots)
+	w.slots[slot] = append(w.slots[slot], t)
+	w.count++
+}
+
+func (w *timerWheel) cancel(t *wheelTimer) {
+	if t.done {
+		return
+	}
+
+	t.done = true
+	w.count--
+}
+
+func (w *timerWheel) visit(slot int, now time.Time, expired []interface{}) []interface{} {
+	var keep []*wheelTimer
+	for _, t := range w.slots[slot] {
+		switch {
+		case t.done:
+		case !now.Before(t.deadline):
+			t.done = true
+			w.count--
+			expired = append(expired, t.value)
+		default:
+			keep = append(keep, t)
+		}
+	}
+
+	w.slots[slot] = keep
+	return expired
+}
+
+// advances the wheel to now, and returns the values of the expired
+// timers
+func (w *timerWheel) expire(now time.Time) []interface{} {
+	steps := int(now.Sub(w.last) / w.resolution)
+	if steps <= 0 {
+		return nil
+	}
+
+	if steps >= len(w.slots) {
+		return w.rehash(now)
+	}
+
+	var expired []interface{}
+	for i := 1; i <= steps; i++ {
+		expired = w.visit((w.current+i)%len(w.slots), now, expired)
+	}
+
+	w.current = (w.current + steps) % len(w.slots)
+	w.last = w.last.Add(time.Duration(steps) * w.resolution)
+	return expired
+}
+
+// when the wheel was not advanced for more than a full turn, the
+// remaining timers are stored again relative to the current time
+func (w *timerWheel) rehash(now time.Time) []interface{} {
+	var (
+		expired []interface{}
+		keep    []*wheelTimer
+	)
+
+	for i := range w.slots {
+		for _, t := range w.slots[i] {
+			switch {
+			case t.done:
+			case !now.Before(t.deadline):
+				t.done = true
+				expired = append(expired, t.value)
+			default:
+				keep = append(keep, t)
+			}
+		}
+
+		w.slots[i] = nil
+	}
+
+	w.current, w.last, w.count = 0, now, 0
+	for _, t := range keep {
+		w.insert(t)
+	}
+
+	return expired
+}
+
+func (w *timerWheel) empty() bool { return w.count == 0 }
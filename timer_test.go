@@ -0,0 +1,124 @@
+package cast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerWheelExpires(t *testing.T) {
+	now := time.Now()
+	w := newTimerWheel(time.Millisecond)
+	w.add(now, now.Add(3*time.Millisecond), 1)
+	w.add(now, now.Add(time.Millisecond), 2)
+	w.add(now, now.Add(200*time.Millisecond), 3)
+
+	if e := w.expire(now.Add(time.Millisecond / 2)); len(e) != 0 {
+		t.Error("unexpected expiration", e)
+	}
+
+	if e := w.expire(now.Add(time.Millisecond)); len(e) != 1 || e[0] != 2 {
+		t.Error("failed to expire", e)
+	}
+
+	if e := w.expire(now.Add(4 * time.Millisecond)); len(e) != 1 || e[0] != 1 {
+		t.Error("failed to expire", e)
+	}
+
+	// more than a full turn later than the start
+	if e := w.expire(now.Add(100 * time.Millisecond)); len(e) != 0 {
+		t.Error("unexpected expiration", e)
+	}
+
+	if w.empty() {
+		t.Error("unexpected empty wheel")
+	}
+
+	if e := w.expire(now.Add(201 * time.Millisecond)); len(e) != 1 || e[0] != 3 {
+		t.Error("failed to expire", e)
+	}
+
+	if !w.empty() {
+		t.Error("failed to empty wheel")
+	}
+}
+
+func TestTimerWheelCancel(t *testing.T) {
+	now := time.Now()
+	w := newTimerWheel(time.Millisecond)
+	t1 := w.add(now, now.Add(time.Millisecond), 1)
+	w.add(now, now.Add(time.Millisecond), 2)
+	w.cancel(t1)
+	w.cancel(t1)
+
+	if e := w.expire(now.Add(time.Millisecond)); len(e) != 1 || e[0] != 2 {
+		t.Error("failed to expire", e)
+	}
+
+	if !w.empty() {
+		t.Error("failed to empty wheel")
+	}
+}
+
+func TestTimerWheelRehash(t *testing.T) {
+	now := time.Now()
+	w := newTimerWheel(time.Millisecond)
+	t1 := w.add(now, now.Add(time.Second), 1)
+	w.add(now, now.Add(2*time.Millisecond), 2)
+
+	if e := w.expire(now.Add(900 * time.Millisecond)); len(e) != 1 || e[0] != 2 {
+		t.Error("failed to expire", e)
+	}
+
+	if e := w.expire(now.Add(999 * time.Millisecond)); len(e) != 0 {
+		t.Error("unexpected expiration", e)
+	}
+
+	if e := w.expire(now.Add(1001 * time.Millisecond)); len(e) != 1 || e[0] != 1 || !t1.done {
+		t.Error("failed to expire", e)
+	}
+}
+
+func TestTimeoutConnection(t *testing.T) {
+	c := NewTimeoutConnection(make(MessageChannel), 15*time.Millisecond)
+	testTimeout(t, func() {
+		c.Send() <- Message{Val: "foo"}
+		c.Send() <- Message{Val: "bar"}
+		vals := make(map[string]bool)
+		ec := c.(interface{ Error() <-chan error }).Error()
+		for i := 0; i < 2; i++ {
+			err := <-ec
+			if terr, ok := err.(*TimeoutError); ok {
+				vals[terr.Message.Val] = true
+			}
+		}
+
+		if !vals["foo"] || !vals["bar"] {
+			t.Error("failed to time out messages", vals)
+		}
+	})
+}
+
+func BenchmarkTimerWheel(b *testing.B) {
+	now := time.Now()
+	w := newTimerWheel(time.Millisecond)
+	for i := 0; i < b.N; i++ {
+		t := w.add(now, now.Add(10*time.Millisecond), i)
+		if i%2 == 0 {
+			w.cancel(t)
+		}
+
+		if i%1000 == 0 {
+			now = now.Add(time.Millisecond)
+			w.expire(now)
+		}
+	}
+}
+
+func BenchmarkAfterFunc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		t := time.AfterFunc(10*time.Millisecond, func() {})
+		if i%2 == 0 {
+			t.Stop()
+		}
+	}
+}
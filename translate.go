@@ -0,0 +1,187 @@
+package cast
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// creates an interface from a URL
+type InterfaceFactory func(*url.URL) (Interface, error)
+
+// translates the messages carrying the address of an interface, using
+// the factory registered for the scheme of the URL
+//
+// the standard message has the key InterfaceKey, and the value is a URL,
+// e.g. tcp://10.0.0.1:9090, unix:///run/cast.sock or
+// ws://example.org/cast. the codecs to be proposed can be set with the
+// codec query parameter, e.g. tcp://10.0.0.1:9090?codec=binary,text.
+type InterfaceRegistry struct {
+	mx        sync.RWMutex
+	factories map[string]InterfaceFactory
+}
+
+// the key of the messages carrying the address of an interface
+var InterfaceKey = []string{"cast", "interface"}
+
+var (
+	// error returned when the URL of an interface is invalid
+	ErrInvalidURL = errors.New("invalid interface url")
+
+	// error returned when no factory is registered for the scheme of the
+	// URL
+	ErrUnknownScheme = errors.New("unknown interface scheme")
+)
+
+// registry containing the built-in transports: tcp, unix, ws and wss
+var DefaultInterfaceRegistry = NewInterfaceRegistry()
+
+var builtinCodecs = []Codec{TextCodec, BinaryCodec}
+
+// creates a message carrying the URL of an interface
+func NewInterfaceMessage(u string) Message {
+	return Message{Key: InterfaceKey, Val: u}
+}
+
+func isInterfaceMessage(m Message) bool {
+	if len(m.Key) != len(InterfaceKey) {
+		return false
+	}
+
+	for i := range InterfaceKey {
+		if m.Key[i] != InterfaceKey[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func urlCodecs(u *url.URL) ([]Codec, error) {
+	q := u.Query().Get("codec")
+	if q == "" {
+		return nil, nil
+	}
+
+	var codecs []Codec
+	for _, name := range strings.Split(q, ",") {
+		c := findCodec(builtinCodecs, name)
+		if c == nil {
+			return nil, ErrInvalidURL
+		}
+
+		codecs = append(codecs, c)
+	}
+
+	return codecs, nil
+}
+
+func codecNames(codecs []Codec) []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+
+	return names
+}
+
+func encodeCodecs(codecs []Codec) string {
+	if len(codecs) == 0 {
+		return ""
+	}
+
+	return "codec=" + strings.Join(codecNames(codecs), ",")
+}
+
+func tcpFactory(u *url.URL) (Interface, error) {
+	if u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	codecs, err := urlCodecs(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return TCPInterface{Address: u.Host, Codecs: codecs}, nil
+}
+
+func unixFactory(u *url.URL) (Interface, error) {
+	if u.Path == "" {
+		return nil, ErrInvalidURL
+	}
+
+	codecs, err := urlCodecs(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnixInterface{Path: u.Path, Codecs: codecs}, nil
+}
+
+func webSocketFactory(u *url.URL) (Interface, error) {
+	if u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	codecs, err := urlCodecs(u)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Del("codec")
+	wu := *u
+	wu.RawQuery = q.Encode()
+	return WebSocketInterface{URL: wu.String(), Codecs: codecs}, nil
+}
+
+// creates a registry with the built-in transports registered
+func NewInterfaceRegistry() *InterfaceRegistry {
+	r := &InterfaceRegistry{factories: make(map[string]InterfaceFactory)}
+	r.Register("tcp", tcpFactory)
+	r.Register("unix", unixFactory)
+	r.Register("ws", webSocketFactory)
+	r.Register("wss", webSocketFactory)
+	return r
+}
+
+// registers a factory for a URL scheme, replacing the previous one
+func (r *InterfaceRegistry) Register(scheme string, f InterfaceFactory) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.factories[strings.ToLower(scheme)] = f
+}
+
+// creates an interface from a URL
+func (r *InterfaceRegistry) Interface(rawurl string) (Interface, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+
+	r.mx.RLock()
+	f, ok := r.factories[strings.ToLower(u.Scheme)]
+	r.mx.RUnlock()
+	if !ok {
+		return nil, ErrUnknownScheme
+	}
+
+	return f(u)
+}
+
+// returns nil when the message is not an interface message, or the URL
+// cannot be translated
+func (r *InterfaceRegistry) Translate(m Message) Interface {
+	if !isInterfaceMessage(m) {
+		return nil
+	}
+
+	i, err := r.Interface(m.Val)
+	if err != nil {
+		return nil
+	}
+
+	return i
+}
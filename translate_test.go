@@ -0,0 +1,120 @@
+package cast
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranslateBuiltin(t *testing.T) {
+	for _, ti := range []struct {
+		url    string
+		expect Interface
+	}{{
+		"tcp://127.0.0.1:9090",
+		TCPInterface{Address: "127.0.0.1:9090"},
+	}, {
+		"tcp://127.0.0.1:9090?codec=binary,text",
+		TCPInterface{Address: "127.0.0.1:9090", Codecs: []Codec{BinaryCodec, TextCodec}},
+	}, {
+		"unix:///run/cast.sock",
+		UnixInterface{Path: "/run/cast.sock"},
+	}, {
+		"ws://example.org/cast?foo=bar&codec=binary",
+		WebSocketInterface{URL: "ws://example.org/cast?foo=bar", Codecs: []Codec{BinaryCodec}},
+	}, {
+		"wss://example.org/cast",
+		WebSocketInterface{URL: "wss://example.org/cast"},
+	}, {
+		"tcp:///no/host", nil,
+	}, {
+		"unix://", nil,
+	}, {
+		"tcp://127.0.0.1:9090?codec=json", nil,
+	}, {
+		"udp://127.0.0.1:9090", nil,
+	}, {
+		"%%", nil,
+	}} {
+		i := DefaultInterfaceRegistry.Translate(NewInterfaceMessage(ti.url))
+		if ti.expect == nil {
+			if i != nil {
+				t.Error(ti.url, "failed to fail", i)
+			}
+
+			continue
+		}
+
+		if i == nil || i.(interface{ String() string }).String() != ti.expect.(interface{ String() string }).String() {
+			t.Error(ti.url, "invalid interface", i)
+		}
+	}
+}
+
+func TestTranslateIgnoresOtherMessages(t *testing.T) {
+	if i := DefaultInterfaceRegistry.Translate(Message{Key: []string{"foo"}, Val: "tcp://127.0.0.1:9090"}); i != nil {
+		t.Error("failed to ignore message")
+	}
+}
+
+func TestTranslateStringRoundTrip(t *testing.T) {
+	for _, i := range []Interface{
+		TCPInterface{Address: "127.0.0.1:9090", Codecs: []Codec{BinaryCodec}},
+		UnixInterface{Path: "/run/cast.sock"},
+		WebSocketInterface{URL: "ws://example.org/cast", Codecs: []Codec{TextCodec, BinaryCodec}},
+	} {
+		s := i.(interface{ String() string }).String()
+		ti, err := DefaultInterfaceRegistry.Interface(s)
+		if err != nil {
+			t.Error(s, err)
+			continue
+		}
+
+		if ts := ti.(interface{ String() string }).String(); ts != s {
+			t.Error("failed to round trip", s, ts)
+		}
+	}
+}
+
+func TestTranslateRegister(t *testing.T) {
+	r := NewInterfaceRegistry()
+	l := make(InProcListener)
+	r.Register("inproc", func(*url.URL) (Interface, error) { return l, nil })
+
+	if i := r.Translate(NewInterfaceMessage("inproc://test")); i == nil {
+		t.Error("failed to translate with registered factory")
+	}
+
+	if _, err := DefaultInterfaceRegistry.Interface("inproc://test"); err != ErrUnknownScheme {
+		t.Error("failed to keep registries separate", err)
+	}
+}
+
+func TestTranslateAndConnect(t *testing.T) {
+	l, err := NewUnixListener(filepath.Join(t.TempDir(), "cast.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	p := NewNode(0, 0)
+	p.Listen(l)
+
+	i := DefaultInterfaceRegistry.Translate(NewInterfaceMessage(l.Interface().String()))
+	if i == nil {
+		t.Fatal("failed to translate")
+	}
+
+	c, err := i.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(0, 0)
+	n.Join(c)
+	testTimeout(t, func() {
+		n.Send() <- Message{Val: "foo"}
+		<-p.Receive()
+	})
+}
@@ -0,0 +1,66 @@
+package cast
+
+import "testing"
+
+func TestTTLLimitsHops(t *testing.T) {
+	nodes := createChain(5)
+
+	testTimeout(t, func() {
+		nodes[0].Send() <- WithTTL(Message{Val: "foo"}, 2)
+		for _, n := range nodes[1:3] {
+			if m := <-n.Receive(); m.Val != "foo" || len(m.Key) != 0 {
+				t.Error("invalid message", m)
+			}
+		}
+	})
+
+	for _, n := range nodes[3:] {
+		expectNoMessage(t, n)
+	}
+
+	if s := nodes[2].(Monitor).Stats(); s.DroppedTTL != 1 {
+		t.Error("failed to count dropped message", s.DroppedTTL)
+	}
+
+	if s := nodes[1].(Monitor).Stats(); s.DroppedTTL != 0 {
+		t.Error("invalid count of dropped messages", s.DroppedTTL)
+	}
+}
+
+func TestTTLOneReachesNeighbours(t *testing.T) {
+	nodes := createTree(13)
+
+	testTimeout(t, func() {
+		nodes[1].Send() <- WithTTL(Message{}, 1)
+		<-nodes[0].Receive()
+		for _, n := range nodes[2:5] {
+			<-n.Receive()
+		}
+	})
+
+	for _, n := range append(nodes[5:], nodes[0]) {
+		expectNoMessage(t, n)
+	}
+}
+
+func TestTTLLeafDoesNotCountDrop(t *testing.T) {
+	nodes := createChain(2)
+	testTimeout(t, func() {
+		nodes[0].Send() <- WithTTL(Message{}, 1)
+		<-nodes[1].Receive()
+	})
+
+	if s := nodes[1].(Monitor).Stats(); s.DroppedTTL != 0 {
+		t.Error("invalid count of dropped messages", s.DroppedTTL)
+	}
+}
+
+func TestNoTTL(t *testing.T) {
+	nodes := createChain(12)
+	testTimeout(t, func() {
+		nodes[0].Send() <- WithTTL(Message{}, 0)
+		for _, n := range nodes[1:] {
+			<-n.Receive()
+		}
+	})
+}
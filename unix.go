@@ -0,0 +1,84 @@
+package cast
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+)
+
+// represents a unix domain socket where it is possible to connect to
+// the codecs are proposed in the order of preference during the
+// handshake. when no codecs are set, the text codec is used.
+type UnixInterface struct {
+	Path   string
+	Codecs []Codec
+}
+
+// listens on a unix domain socket and provides a connection for each
+// accepted socket connection
+// stop listening by calling Close, which also removes the socket file
+type UnixListener struct {
+	*streamListener
+}
+
+// error returned when the socket file is used by an active listener
+var ErrSocketInUse = errors.New("socket in use")
+
+// dials the socket, negotiates the codec, and returns a connection
+// closing send closes the socket connection
+// receive is closed when the remote end hangs up
+func (i UnixInterface) Connect() (Connection, error) {
+	return dialStream("unix", i.Path, i.Codecs)
+}
+
+// removes the socket file when it was left behind by a listener that
+// is not running anymore
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return &os.PathError{Op: "listen", Path: path, Err: errors.New("not a socket")}
+	}
+
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return ErrSocketInUse
+	}
+
+	return os.Remove(path)
+}
+
+// starts listening on the path
+// when a stale socket file exists on the path, it is removed
+// the listener accepts the codecs proposed by the connecting side if
+// they are in the codecs argument. when no codecs are set, both the text
+// and the binary codecs are accepted.
+func NewUnixListener(path string, codecs ...Codec) (*UnixListener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	nl, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnixListener{newStreamListener(nl, codecs)}, nil
+}
+
+// an interface that can be used to connect to the listener
+func (l *UnixListener) Interface() UnixInterface {
+	return UnixInterface{Path: l.Addr().String()}
+}
+
+// the URL representing the interface
+func (i UnixInterface) String() string {
+	u := url.URL{Scheme: "unix", Path: i.Path, RawQuery: encodeCodecs(i.Codecs)}
+	return u.String()
+}
@@ -0,0 +1,103 @@
+package cast
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixMessaging(t *testing.T) {
+	l, err := NewUnixListener(filepath.Join(t.TempDir(), "cast.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	parent := NewNode(0, 0)
+	parent.Listen(l)
+
+	c, err := l.Interface().Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewNode(0, 0)
+	child.Join(c)
+
+	testTimeout(t, func() {
+		parent.Send() <- Message{Val: "foo"}
+		if m := <-child.Receive(); m.Val != "foo" {
+			t.Error("failed to receive message", m)
+		}
+
+		child.Send() <- Message{Val: "bar"}
+		if m := <-parent.Receive(); m.Val != "bar" {
+			t.Error("failed to receive message", m)
+		}
+	})
+
+	close(parent.Send())
+	testTimeout(t, func() {
+		for {
+			if err := <-child.Error(); errors.Is(err, ErrDisconnected) {
+				return
+			}
+		}
+	})
+}
+
+func TestUnixRemovesStaleSocket(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cast.sock")
+	nl, err := net.ListenUnix("unix", &net.UnixAddr{Name: p, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nl.SetUnlinkOnClose(false)
+	nl.Close()
+	if _, err := os.Stat(p); err != nil {
+		t.Fatal("failed to leave stale socket")
+	}
+
+	l, err := NewUnixListener(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Close()
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Error("failed to remove socket on close")
+	}
+}
+
+func TestUnixSocketInUse(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cast.sock")
+	l, err := NewUnixListener(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	if _, err := NewUnixListener(p); err != ErrSocketInUse {
+		t.Error("failed to detect socket in use", err)
+	}
+}
+
+func TestUnixNotASocket(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "cast.sock")
+	if err := os.WriteFile(p, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewUnixListener(p); err == nil {
+		t.Error("failed to fail")
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		t.Error("file removed")
+	}
+}
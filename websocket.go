@@ -0,0 +1,557 @@
+package cast
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	webSocketGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketProtocolPrefix = "cast."
+	maxWebSocketFrame       = 1 << 26
+)
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// represents a WebSocket endpoint where it is possible to connect to,
+// with a ws:// or wss:// URL
+// every message is sent in a single WebSocket frame
+// the codecs are proposed as WebSocket subprotocols, 'cast.text' and
+// 'cast.binary', in the order of preference. when no codecs are set,
+// the text codec is used.
+type WebSocketInterface struct {
+	URL       string
+	Codecs    []Codec
+	Header    http.Header
+	TLSConfig *tls.Config
+}
+
+// an http.Handler that upgrades the incoming requests to WebSocket,
+// and provides a connection for each of them
+// stop listening by calling Close
+type WebSocketListener struct {
+	codecs      []Codec
+	connections chan Connection
+	closed      chan struct{}
+	mx          sync.Mutex
+	isClosed    bool
+	handlers    sync.WaitGroup
+}
+
+// a byte stream over a WebSocket connection. every write is sent as a
+// single frame, and reading reads the payload of the data frames
+// sequentially, while handling the control frames.
+type webSocketStream struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	client    bool
+	opcode    byte
+	remaining uint64
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	eof       bool
+	writeMx   sync.Mutex
+	closeOnce sync.Once
+}
+
+var (
+	// error returned when the remote end doesn't respond with a valid
+	// WebSocket handshake
+	ErrWebSocketHandshake = errors.New("invalid websocket handshake")
+
+	// error returned when the remote end violates the WebSocket
+	// protocol
+	ErrWebSocketProtocol = errors.New("websocket protocol error")
+)
+
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, key, value string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, vi := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(vi), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func webSocketProtocols(h http.Header) []string {
+	var p []string
+	for _, v := range h[http.CanonicalHeaderKey("Sec-WebSocket-Protocol")] {
+		for _, vi := range strings.Split(v, ",") {
+			p = append(p, strings.TrimSpace(vi))
+		}
+	}
+
+	return p
+}
+
+func webSocketOpcode(c Codec) byte {
+	if c == TextCodec {
+		return wsText
+	}
+
+	return wsBinary
+}
+
+func newWebSocketStream(c net.Conn, r *bufio.Reader, client bool, codec Codec) *webSocketStream {
+	return &webSocketStream{
+		conn:   c,
+		reader: r,
+		client: client,
+		opcode: webSocketOpcode(codec)}
+}
+
+func (s *webSocketStream) writeFrame(opcode byte, payload []byte) error {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	frame := make([]byte, 2, 14+len(payload))
+	frame[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if !s.client {
+		_, err := s.conn.Write(append(frame, payload...))
+		return err
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+
+	frame[1] |= 0x80
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := s.conn.Write(frame)
+	return err
+}
+
+func (s *webSocketStream) readControl(opcode byte, length uint64) error {
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, payload); err != nil {
+		return err
+	}
+
+	if s.masked {
+		for i := range payload {
+			payload[i] ^= s.mask[i%4]
+		}
+	}
+
+	switch opcode {
+	case wsPing:
+		return s.writeFrame(wsPong, payload)
+	case wsClose:
+		s.eof = true
+		s.closeOnce.Do(func() {
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+
+			s.writeFrame(wsClose, payload)
+		})
+
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+// reads the next frame header, and handles the control frames
+func (s *webSocketStream) nextFrame() error {
+	var h [2]byte
+	if _, err := io.ReadFull(s.reader, h[:]); err != nil {
+		return err
+	}
+
+	fin, opcode := h[0]&0x80 != 0, h[0]&0x0f
+	s.masked = h[1]&0x80 != 0
+	if h[0]&0x70 != 0 || s.masked == s.client {
+		return ErrWebSocketProtocol
+	}
+
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err := io.ReadFull(s.reader, l[:]); err != nil {
+			return err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err := io.ReadFull(s.reader, l[:]); err != nil {
+			return err
+		}
+
+		length = binary.BigEndian.Uint64(l[:])
+	}
+
+	if s.masked {
+		if _, err := io.ReadFull(s.reader, s.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		if length > maxWebSocketFrame {
+			return ErrWebSocketProtocol
+		}
+
+		s.remaining = length
+		s.maskPos = 0
+		return nil
+	case wsClose, wsPing, wsPong:
+		if !fin || length > 125 {
+			return ErrWebSocketProtocol
+		}
+
+		return s.readControl(opcode, length)
+	default:
+		return ErrWebSocketProtocol
+	}
+}
+
+func (s *webSocketStream) Read(p []byte) (int, error) {
+	for s.remaining == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+
+		if err := s.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+
+	n, err := s.reader.Read(p)
+	if s.masked {
+		for i := range p[:n] {
+			p[i] ^= s.mask[(s.maskPos+i)%4]
+		}
+
+		s.maskPos += n
+	}
+
+	s.remaining -= uint64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// the encoders write every message in a single call, so every message
+// is sent in a single frame
+func (s *webSocketStream) Write(p []byte) (int, error) {
+	if err := s.writeFrame(s.opcode, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// sends a normal closure frame, unless already sent in response to the
+// remote end, and closes the network connection
+func (s *webSocketStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.writeFrame(wsClose, []byte{0x03, 0xe8})
+	})
+
+	return s.conn.Close()
+}
+
+func (s *webSocketStream) RemoteAddr() net.Addr { return s.conn.RemoteAddr() }
+
+func dialWebSocket(u *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	if u.Scheme == "ws" {
+		return net.Dial("tcp", host)
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	return tls.Dial("tcp", host, tlsConfig)
+}
+
+func (i WebSocketInterface) handshake(c net.Conn, u *url.URL, codecs []Codec) (*bufio.Reader, Codec, error) {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, nil, err
+	}
+
+	hu := *u
+	hu.Scheme = "http"
+	req, err := http.NewRequest("GET", hu.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range i.Header {
+		req.Header[k] = v
+	}
+
+	protocols := codecNames(codecs)
+	for i := range protocols {
+		protocols[i] = webSocketProtocolPrefix + protocols[i]
+	}
+
+	encodedKey := base64.StdEncoding.EncodeToString(key[:])
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", encodedKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	if err := req.Write(c); err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(c)
+	rsp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rsp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(rsp.Header, "Upgrade", "websocket") ||
+		rsp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(encodedKey) {
+		return nil, nil, ErrWebSocketHandshake
+	}
+
+	codec := findCodec(codecs, strings.TrimPrefix(rsp.Header.Get("Sec-WebSocket-Protocol"), webSocketProtocolPrefix))
+	if codec == nil {
+		return nil, nil, ErrNoCommonCodec
+	}
+
+	return r, codec, nil
+}
+
+// dials the URL, upgrades the connection to WebSocket, and returns a
+// connection representing it
+// closing send closes the WebSocket connection
+// receive is closed when the remote end closes the connection
+func (i WebSocketInterface) Connect() (Connection, error) {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, errors.New("unsupported websocket scheme: " + u.Scheme)
+	}
+
+	codecs := i.Codecs
+	if len(codecs) == 0 {
+		codecs = []Codec{TextCodec}
+	}
+
+	c, err := dialWebSocket(u, i.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	r, codec, err := i.handshake(c, u, codecs)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return newStreamConnection(newWebSocketStream(c, r, true, codec), codec, true, true), nil
+}
+
+// creates a WebSocket listener, that needs to be registered as an HTTP
+// handler
+// the listener accepts the codecs proposed by the connecting side if
+// they are in the codecs argument. when no codecs are set, both the text
+// and the binary codecs are accepted. when the connecting side doesn't
+// propose a subprotocol, the text codec is used.
+func NewWebSocketListener(codecs ...Codec) *WebSocketListener {
+	if len(codecs) == 0 {
+		codecs = []Codec{TextCodec, BinaryCodec}
+	}
+
+	return &WebSocketListener{
+		codecs:      codecs,
+		connections: make(chan Connection),
+		closed:      make(chan struct{})}
+}
+
+func (l *WebSocketListener) selectCodec(r *http.Request) (Codec, string) {
+	protocols := webSocketProtocols(r.Header)
+	if len(protocols) == 0 {
+		return findCodec(l.codecs, TextCodec.Name()), ""
+	}
+
+	for _, p := range protocols {
+		if !strings.HasPrefix(p, webSocketProtocolPrefix) {
+			continue
+		}
+
+		if c := findCodec(l.codecs, strings.TrimPrefix(p, webSocketProtocolPrefix)); c != nil {
+			return c, p
+		}
+	}
+
+	return nil, ""
+}
+
+func (l *WebSocketListener) upgrade(w http.ResponseWriter, r *http.Request) (Connection, error) {
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, ErrWebSocketHandshake
+	}
+
+	codec, protocol := l.selectCodec(r)
+	if codec == nil {
+		http.Error(w, "no supported subprotocol", http.StatusBadRequest)
+		return nil, ErrNoCommonCodec
+	}
+
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrWebSocketHandshake
+	}
+
+	c, rw, err := h.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+	if protocol != "" {
+		rw.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return newStreamConnection(newWebSocketStream(c, rw.Reader, false, codec), codec, false, false), nil
+}
+
+// upgrades the request and passes the connection to the node listening
+// blocks until the connection is taken, or the listener is closed
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mx.Lock()
+	if l.isClosed {
+		l.mx.Unlock()
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	l.handlers.Add(1)
+	l.mx.Unlock()
+	defer l.handlers.Done()
+
+	c, err := l.upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	select {
+	case l.connections <- c:
+	case <-l.closed:
+		close(c.Send())
+	}
+}
+
+func (l *WebSocketListener) Connections() <-chan Connection { return l.connections }
+
+// stops accepting connections and closes the connections channel
+// connections already accepted are not closed
+func (l *WebSocketListener) Close() error {
+	l.mx.Lock()
+	if l.isClosed {
+		l.mx.Unlock()
+		return nil
+	}
+
+	l.isClosed = true
+	close(l.closed)
+	l.mx.Unlock()
+
+	l.handlers.Wait()
+	close(l.connections)
+	return nil
+}
+
+// the URL representing the interface, including the codecs
+func (i WebSocketInterface) String() string {
+	u, err := url.Parse(i.URL)
+	if err != nil || len(i.Codecs) == 0 {
+		return i.URL
+	}
+
+	q := u.Query()
+	q.Set("codec", strings.Join(codecNames(i.Codecs), ","))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
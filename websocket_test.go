@@ -0,0 +1,123 @@
+package cast
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createWebSocketPair(t *testing.T, codecs ...Codec) (Node, Node, *WebSocketListener, *httptest.Server) {
+	l := NewWebSocketListener()
+	s := httptest.NewServer(l)
+
+	parent := NewNode(0, 0)
+	parent.Listen(l)
+
+	c, err := WebSocketInterface{URL: "ws" + strings.TrimPrefix(s.URL, "http"), Codecs: codecs}.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewNode(0, 0)
+	child.Join(c)
+	return parent, child, l, s
+}
+
+func TestWebSocketMessaging(t *testing.T) {
+	for _, codec := range []Codec{TextCodec, BinaryCodec} {
+		parent, child, l, s := createWebSocketPair(t, codec)
+
+		large := strings.Repeat("x", 1<<17)
+		testTimeout(t, func() {
+			parent.Send() <- Message{Key: []string{"foo", "bar"}, Val: "baz\nqux", Comment: "quux"}
+			m := <-child.Receive()
+			if len(m.Key) != 2 || m.Key[1] != "bar" || m.Val != "baz\nqux" || m.Comment != "quux" {
+				t.Error(codec.Name(), "failed to receive message", m)
+			}
+
+			child.Send() <- Message{Val: large}
+			if m := <-parent.Receive(); m.Val != large {
+				t.Error(codec.Name(), "failed to receive large message")
+			}
+
+			child.Send() <- Message{Val: "short"}
+			if m := <-parent.Receive(); m.Val != "short" {
+				t.Error(codec.Name(), "failed to receive message", m)
+			}
+		})
+
+		s.Close()
+		l.Close()
+	}
+}
+
+func TestWebSocketRemoteClose(t *testing.T) {
+	parent, child, l, s := createWebSocketPair(t)
+	defer s.Close()
+	defer l.Close()
+
+	testTimeout(t, func() {
+		child.Send() <- Message{}
+		<-parent.Receive()
+	})
+
+	close(parent.Send())
+	testTimeout(t, func() {
+		for {
+			if err := <-child.Error(); errors.Is(err, ErrDisconnected) {
+				return
+			}
+		}
+	})
+}
+
+func TestWebSocketRejectsPlainRequest(t *testing.T) {
+	l := NewWebSocketListener()
+	s := httptest.NewServer(l)
+	defer s.Close()
+	defer l.Close()
+
+	rsp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Error("failed to reject request", rsp.StatusCode)
+	}
+}
+
+func TestWebSocketNoCommonCodec(t *testing.T) {
+	l := NewWebSocketListener(TextCodec)
+	s := httptest.NewServer(l)
+	defer s.Close()
+	defer l.Close()
+
+	_, err := WebSocketInterface{
+		URL:    "ws" + strings.TrimPrefix(s.URL, "http"),
+		Codecs: []Codec{BinaryCodec},
+	}.Connect()
+	if err != ErrWebSocketHandshake {
+		t.Error("failed to fail", err)
+	}
+}
+
+func TestWebSocketCloseListener(t *testing.T) {
+	l := NewWebSocketListener()
+	s := httptest.NewServer(l)
+	defer s.Close()
+
+	l.Close()
+	testTimeout(t, func() {
+		if _, open := <-l.Connections(); open {
+			t.Error("failed to close connections")
+		}
+	})
+
+	if _, err := (WebSocketInterface{URL: "ws" + strings.TrimPrefix(s.URL, "http")}).Connect(); err == nil {
+		t.Error("failed to reject connection")
+	}
+}